@@ -0,0 +1,75 @@
+package bplustree
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+/* the fan-outs DEFAULT_ORDER and DEFAULT_ENTRIES were picked from */
+var bench_orders = []int{16, 32, 64, 128, 256, 512, 1024}
+
+const bench_keys = 1 << 20
+
+/* a million random int64 keys and the same keys as 21 byte strings */
+func bench_key_set() ([]int64, []string) {
+	r := rand.New(rand.NewSource(1))
+	ints := make([]int64, bench_keys)
+	strs := make([]string, bench_keys)
+	for i := range ints {
+		ints[i] = r.Int63()
+		strs[i] = fmt.Sprintf("user:%016x", ints[i])
+	}
+	return ints, strs
+}
+
+/* put keys into a fresh tree, starting another each time they run out */
+func bench_put[K cmp.Ordered](b *testing.B, order int, keys []K) {
+	var tree *Tree[K, int]
+	for i := 0; i < b.N; i++ {
+		if i%len(keys) == 0 {
+			b.StopTimer()
+			tree = New[K, int](WithOrder(order), WithEntries(order))
+			b.StartTimer()
+		}
+		tree.Put(keys[i%len(keys)], i)
+	}
+}
+
+func bench_get[K cmp.Ordered](b *testing.B, order int, keys []K) {
+	tree := New[K, int](WithOrder(order), WithEntries(order))
+	for i, key := range keys {
+		tree.Put(key, i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := tree.Get(keys[i%len(keys)]); !ok {
+			b.Fatalf("key %v missing", keys[i%len(keys)])
+		}
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	ints, strs := bench_key_set()
+	for _, order := range bench_orders {
+		b.Run(fmt.Sprintf("int64/%d", order), func(b *testing.B) {
+			bench_put(b, order, ints)
+		})
+		b.Run(fmt.Sprintf("string/%d", order), func(b *testing.B) {
+			bench_put(b, order, strs)
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	ints, strs := bench_key_set()
+	for _, order := range bench_orders {
+		b.Run(fmt.Sprintf("int64/%d", order), func(b *testing.B) {
+			bench_get(b, order, ints)
+		})
+		b.Run(fmt.Sprintf("string/%d", order), func(b *testing.B) {
+			bench_get(b, order, strs)
+		})
+	}
+}
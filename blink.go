@@ -0,0 +1,456 @@
+package bplustree
+
+import (
+	"cmp"
+	"iter"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * B-link tree
+ *
+ * A Lehman-Yao B-link tree. Every node carries a high key, the exclusive
+ * upper bound of the keys below it, and a right-link to the next node on
+ * its level, the rightmost node of a level having no high key. A reader
+ * that lands on a node whose high key is not above its search key was
+ * overtaken by a split and simply follows the right-link, so readers
+ * take no locks at all.
+ *
+ * Node contents are immutable blink_state values published through an
+ * atomic pointer. A writer locks the node it changes, builds a new state
+ * and stores it; readers load whichever state is current and never see
+ * a half-made change. A split publishes the new right node first and
+ * then the shrunken left node pointing at it, so every key is reachable
+ * at all times. Writers lock bottom-up and left to right, one level at a
+ * time, holding a child only until its parent is locked.
+ *
+ * Removals never merge nodes. Leaves may become sparse or empty and keep
+ * their place in the tree, which is what lets readers go without locks.
+ */
+
+type blink_node[K, V any] struct {
+	mu    sync.Mutex
+	level int /* 0 for leaves */
+	state atomic.Pointer[blink_state[K, V]]
+}
+
+type blink_state[K, V any] struct {
+	key      []K
+	data     []V                 /* leaves */
+	sub_ptr  []*blink_node[K, V] /* non-leaves, one more than key */
+	high     K
+	has_high bool
+	right    *blink_node[K, V]
+}
+
+type blink_tree[K, V any] struct {
+	root_mu sync.Mutex /* serializes growing a new root */
+	root    atomic.Pointer[blink_node[K, V]]
+	order   int
+	entries int
+	compare func(a, b K) int
+}
+
+func blink_node_new[K, V any](level int, state *blink_state[K, V]) *blink_node[K, V] {
+	node := &blink_node[K, V]{level: level}
+	node.state.Store(state)
+	return node
+}
+
+/* the key is past this node and lives further right */
+func blink_move_right[K, V any](tree *blink_tree[K, V], s *blink_state[K, V], key K) bool {
+	return s.has_high && tree.compare(key, s.high) >= 0
+}
+
+func blink_child[K, V any](tree *blink_tree[K, V], s *blink_state[K, V], key K) *blink_node[K, V] {
+	var i int = key_binary_search(s.key, len(s.key), key, tree.compare)
+	if i >= 0 {
+		return s.sub_ptr[i+1]
+	}
+	return s.sub_ptr[-i-1]
+}
+
+/*
+ * descend without locks to the node covering key on the given level,
+ * returning it with the state its high key was checked against; a
+ * later load of the node's state may already have split the key away
+ */
+func blink_locate[K, V any](tree *blink_tree[K, V], key K, level int, stack *[]*blink_node[K, V]) (*blink_node[K, V], *blink_state[K, V]) {
+
+	var node *blink_node[K, V] = tree.root.Load()
+
+	for {
+		s := node.state.Load()
+		if blink_move_right(tree, s, key) {
+			node = s.right
+			continue
+		}
+		if node.level == level {
+			return node, s
+		}
+		if stack != nil {
+			*stack = append(*stack, node)
+		}
+		node = blink_child(tree, s, key)
+	}
+}
+
+/* lock the node covering key, starting from node and moving right */
+func blink_lock_covering[K, V any](tree *blink_tree[K, V], node *blink_node[K, V], key K) *blink_node[K, V] {
+
+	node.mu.Lock()
+	for {
+		s := node.state.Load()
+		if !blink_move_right(tree, s, key) {
+			return node
+		}
+		right := s.right
+		right.mu.Lock()
+		node.mu.Unlock()
+		node = right
+	}
+}
+
+func blink_tree_search[K, V any](tree *blink_tree[K, V], key K) (V, bool) {
+
+	_, s := blink_locate(tree, key, 0, nil)
+
+	var i int = key_binary_search(s.key, len(s.key), key, tree.compare)
+	if i >= 0 {
+		return s.data[i], true
+	}
+	var zero V
+	return zero, false
+}
+
+/* make room at position i, returning fresh copies */
+func blink_insert_at[T any](arr []T, i int, item T) []T {
+	out := make([]T, len(arr)+1)
+	copy(out, arr[:i])
+	out[i] = item
+	copy(out[i+1:], arr[i:])
+	return out
+}
+
+/*
+ * Hand the separator and new right node of a split on the given level to
+ * the level above. node is locked and is released once the parent is.
+ */
+func blink_insert_parent[K, V any](tree *blink_tree[K, V], node *blink_node[K, V], key K, right *blink_node[K, V], stack []*blink_node[K, V]) {
+
+	var parent *blink_node[K, V]
+
+	if len(stack) > 0 {
+		parent = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+	} else {
+		for {
+			tree.root_mu.Lock()
+			root := tree.root.Load()
+			if root == node {
+				/* new root */
+				root = blink_node_new(node.level+1, &blink_state[K, V]{
+					key:     []K{key},
+					sub_ptr: []*blink_node[K, V]{node, right},
+				})
+				tree.root.Store(root)
+				tree.root_mu.Unlock()
+				node.mu.Unlock()
+				return
+			}
+			if root.level > node.level {
+				/* the tree grew above us since we descended */
+				parent, _ = blink_locate(tree, key, node.level+1, nil)
+				tree.root_mu.Unlock()
+				break
+			}
+			/* the root to our left split and has yet to grow a new root */
+			tree.root_mu.Unlock()
+			runtime.Gosched()
+		}
+	}
+
+	parent = blink_lock_covering(tree, parent, key)
+	node.mu.Unlock()
+	blink_insert_sub(tree, parent, key, right, stack)
+}
+
+/* insert key and the node to its right into the locked non-leaf node, releasing it */
+func blink_insert_sub[K, V any](tree *blink_tree[K, V], node *blink_node[K, V], key K, sub *blink_node[K, V], stack []*blink_node[K, V]) {
+
+	var s *blink_state[K, V] = node.state.Load()
+
+	var i int = key_binary_search(s.key, len(s.key), key, tree.compare)
+	assert(i < 0, 180)
+	i = -i - 1
+
+	keys := blink_insert_at(s.key, i, key)
+	subs := blink_insert_at(s.sub_ptr, i+1, sub)
+
+	if len(subs) <= tree.order {
+		/* simple insertion */
+		node.state.Store(&blink_state[K, V]{
+			key:      keys,
+			sub_ptr:  subs,
+			high:     s.high,
+			has_high: s.has_high,
+			right:    s.right,
+		})
+		node.mu.Unlock()
+		return
+	}
+
+	/* split = [m/2], the key between the halves moves up */
+	var split int = (len(subs) + 1) / 2
+	right := blink_node_new(node.level, &blink_state[K, V]{
+		key:      keys[split:],
+		sub_ptr:  subs[split:],
+		high:     s.high,
+		has_high: s.has_high,
+		right:    s.right,
+	})
+	node.state.Store(&blink_state[K, V]{
+		key:      keys[: split-1 : split-1],
+		sub_ptr:  subs[:split:split],
+		high:     keys[split-1],
+		has_high: true,
+		right:    right,
+	})
+	blink_insert_parent(tree, node, keys[split-1], right, stack)
+}
+
+/* insert or replace key in the tree, returning the replaced value */
+func blink_tree_put[K, V any](tree *blink_tree[K, V], key K, data V) (V, bool) {
+
+	var buf [8]*blink_node[K, V]
+	var stack []*blink_node[K, V] = buf[:0]
+	leaf, _ := blink_locate(tree, key, 0, &stack)
+
+	leaf = blink_lock_covering(tree, leaf, key)
+	var s *blink_state[K, V] = leaf.state.Load()
+
+	var i int = key_binary_search(s.key, len(s.key), key, tree.compare)
+	if i >= 0 {
+		/* replace */
+		data_copy := append([]V(nil), s.data...)
+		old := data_copy[i]
+		data_copy[i] = data
+		leaf.state.Store(&blink_state[K, V]{
+			key:      s.key,
+			data:     data_copy,
+			high:     s.high,
+			has_high: s.has_high,
+			right:    s.right,
+		})
+		leaf.mu.Unlock()
+		return old, true
+	}
+	i = -i - 1
+
+	keys := blink_insert_at(s.key, i, key)
+	datas := blink_insert_at(s.data, i, data)
+
+	if len(keys) <= tree.entries {
+		/* simple insertion */
+		leaf.state.Store(&blink_state[K, V]{
+			key:      keys,
+			data:     datas,
+			high:     s.high,
+			has_high: s.has_high,
+			right:    s.right,
+		})
+		leaf.mu.Unlock()
+		var zero V
+		return zero, false
+	}
+
+	/* split = [m/2], the right half's first key is the separator */
+	var split int = (len(keys) + 1) / 2
+	right := blink_node_new(0, &blink_state[K, V]{
+		key:      keys[split:],
+		data:     datas[split:],
+		high:     s.high,
+		has_high: s.has_high,
+		right:    s.right,
+	})
+	leaf.state.Store(&blink_state[K, V]{
+		key:      keys[:split:split],
+		data:     datas[:split:split],
+		high:     keys[split],
+		has_high: true,
+		right:    right,
+	})
+	blink_insert_parent(tree, leaf, keys[split], right, stack)
+
+	var zero V
+	return zero, false
+}
+
+/* remove key from its leaf, nodes are never merged */
+func blink_tree_delete[K, V any](tree *blink_tree[K, V], key K) (V, bool) {
+
+	leaf, _ := blink_locate(tree, key, 0, nil)
+
+	leaf = blink_lock_covering(tree, leaf, key)
+	defer leaf.mu.Unlock()
+	var s *blink_state[K, V] = leaf.state.Load()
+
+	var i int = key_binary_search(s.key, len(s.key), key, tree.compare)
+	if i < 0 {
+		var zero V
+		return zero, false
+	}
+
+	keys := make([]K, 0, len(s.key)-1)
+	keys = append(append(keys, s.key[:i]...), s.key[i+1:]...)
+	datas := make([]V, 0, len(s.data)-1)
+	datas = append(append(datas, s.data[:i]...), s.data[i+1:]...)
+	leaf.state.Store(&blink_state[K, V]{
+		key:      keys,
+		data:     datas,
+		high:     s.high,
+		has_high: s.has_high,
+		right:    s.right,
+	})
+	return s.data[i], true
+}
+
+/* walk the leaf level from min, without locks, in ascending order */
+func blink_tree_get_range[K, V any](tree *blink_tree[K, V], min K, max K, flags int, fn func(key K, data V) bool) {
+
+	var node *blink_node[K, V]
+	var i int
+
+	if flags&RANGE_NO_MIN != 0 {
+		node = tree.root.Load()
+		for node.level > 0 {
+			node = node.state.Load().sub_ptr[0]
+		}
+	} else {
+		node, _ = blink_locate(tree, min, 0, nil)
+	}
+
+	for node != nil {
+		s := node.state.Load()
+		for i = 0; i < len(s.key); i++ {
+			if flags&RANGE_NO_MIN == 0 {
+				c := tree.compare(s.key[i], min)
+				if c < 0 || (c == 0 && flags&RANGE_EXCLUDE_MIN != 0) {
+					continue
+				}
+			}
+			if flags&RANGE_NO_MAX == 0 {
+				c := tree.compare(s.key[i], max)
+				if c > 0 || (c == 0 && flags&RANGE_EXCLUDE_MAX != 0) {
+					return
+				}
+			}
+			if !fn(s.key[i], s.data[i]) {
+				return
+			}
+		}
+		node = s.right
+	}
+}
+
+func blink_tree_init[K, V any](order int, entries int, compare func(a, b K) int) *blink_tree[K, V] {
+	assert(order > MIN_ORDER && entries >= MIN_ORDER, 353)
+
+	tree := &blink_tree[K, V]{
+		order:   order,
+		entries: entries,
+		compare: compare,
+	}
+	tree.root.Store(blink_node_new(0, &blink_state[K, V]{}))
+	return tree
+}
+
+// BLinkTree is an in-memory B-link tree mapping keys of type K to values
+// of type V, built for read-heavy workloads on many cores. The zero value
+// is not usable; create trees with NewBLink or NewBLinkFunc.
+//
+// A BLinkTree is safe for concurrent use. Lookups and scans take no locks
+// and never wait for writers, even while nodes are being split. Writers
+// lock only the nodes they change. Removing keys never merges nodes, so
+// a tree that shrinks a lot keeps its peak size.
+type BLinkTree[K, V any] struct {
+	tree  *blink_tree[K, V]
+	count atomic.Int64
+}
+
+// NewBLink returns an empty B-link tree of naturally ordered keys. It
+// accepts WithOrder and WithEntries and panics if either is out of
+// range; WithLatchCrabbing has no effect.
+func NewBLink[K cmp.Ordered, V any](opts ...Option) *BLinkTree[K, V] {
+	return NewBLinkFunc[K, V](cmp.Compare[K], opts...)
+}
+
+// NewBLinkFunc is like NewBLink but orders keys with compare, which has
+// the same contract as for NewFunc.
+func NewBLinkFunc[K, V any](compare func(a, b K) int, opts ...Option) *BLinkTree[K, V] {
+	c := config{
+		order:   DEFAULT_ORDER,
+		entries: DEFAULT_ENTRIES,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.order <= MIN_ORDER {
+		panic("bplustree: order out of range")
+	}
+	if c.entries < MIN_ORDER {
+		panic("bplustree: entries out of range")
+	}
+	return &BLinkTree[K, V]{tree: blink_tree_init[K, V](c.order, c.entries, compare)}
+}
+
+// Get returns the value stored under key and reports whether key was
+// present.
+func (t *BLinkTree[K, V]) Get(key K) (V, bool) {
+	return blink_tree_search(t.tree, key)
+}
+
+// Put stores value under key, overwriting any existing value. It
+// returns the previous value and reports whether one was replaced.
+func (t *BLinkTree[K, V]) Put(key K, value V) (V, bool) {
+	old, replaced := blink_tree_put(t.tree, key, value)
+	if !replaced {
+		t.count.Add(1)
+	}
+	return old, replaced
+}
+
+// Delete removes key from the tree, returning the value it held and
+// whether it was present.
+func (t *BLinkTree[K, V]) Delete(key K) (V, bool) {
+	value, ok := blink_tree_delete(t.tree, key)
+	if ok {
+		t.count.Add(-1)
+	}
+	return value, ok
+}
+
+// Len returns the number of keys stored in the tree.
+func (t *BLinkTree[K, V]) Len() int {
+	return int(t.count.Load())
+}
+
+// Range returns an iterator over the keys between lo and hi in ascending
+// order, with the same bounds as Tree.Range. Each leaf is read as of one
+// moment, so concurrent changes may or may not be observed, but keys are
+// never visited twice or out of order.
+func (t *BLinkTree[K, V]) Range(lo, hi K, opts ...RangeOption) iter.Seq2[K, V] {
+	flags := rangeFlags(opts)
+	return func(yield func(K, V) bool) {
+		blink_tree_get_range(t.tree, lo, hi, flags, yield)
+	}
+}
+
+// Ascend calls fn for every key in ascending order until fn returns
+// false.
+func (t *BLinkTree[K, V]) Ascend(fn func(key K, value V) bool) {
+	var zero K
+	blink_tree_get_range(t.tree, zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, fn)
+}
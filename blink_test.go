@@ -0,0 +1,119 @@
+package bplustree
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestBLinkModel(t *testing.T) {
+	tree := NewBLink[int, int](WithOrder(4), WithEntries(3))
+	model := map[int]int{}
+	r := rand.New(rand.NewSource(1))
+	for op := 0; op < 20000; op++ {
+		key := r.Intn(2000)
+		if r.Intn(3) == 0 {
+			_, want := model[key]
+			if _, ok := tree.Delete(key); ok != want {
+				t.Fatalf("Delete(%d) = %t, want %t", key, ok, want)
+			}
+			delete(model, key)
+		} else {
+			old, want := model[key]
+			if got, ok := tree.Put(key, op); ok != want || got != old {
+				t.Fatalf("Put(%d) = %d, %t, want %d, %t", key, got, ok, old, want)
+			}
+			model[key] = op
+		}
+	}
+	if tree.Len() != len(model) {
+		t.Fatalf("Len = %d, want %d", tree.Len(), len(model))
+	}
+	n, prev := 0, -1
+	for key, value := range tree.Range(0, 2000) {
+		if key <= prev || model[key] != value {
+			t.Fatalf("Range gave %d => %d after %d, want %d", key, value, prev, model[key])
+		}
+		prev = key
+		n++
+	}
+	if n != len(model) {
+		t.Fatalf("Range gave %d keys, want %d", n, len(model))
+	}
+}
+
+// TestBLinkStress runs writers that keep splitting nodes against
+// lock-free readers. The even keys are put before the writers start and
+// never deleted, so a Get or Range that misses one was overtaken by a
+// split and failed to move right. Run it with -race.
+func TestBLinkStress(t *testing.T) {
+	const keys, writers, readers = 4000, 4, 4
+	tree := NewBLink[int, int](WithOrder(4), WithEntries(3))
+	for key := 0; key < keys; key += 2 {
+		tree.Put(key, key)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(g)))
+			for op := 0; op < 20000; op++ {
+				key := r.Intn(keys*2) | 1
+				if r.Intn(2) == 0 {
+					tree.Put(key, key)
+				} else {
+					tree.Delete(key)
+				}
+			}
+		}(g)
+	}
+	var rg sync.WaitGroup
+	for g := 0; g < readers; g++ {
+		rg.Add(1)
+		go func(g int) {
+			defer rg.Done()
+			r := rand.New(rand.NewSource(int64(100 + g)))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := r.Intn(keys/2) * 2
+				if value, ok := tree.Get(key); !ok || value != key {
+					t.Errorf("Get(%d) = %d, %t", key, value, ok)
+					return
+				}
+				if value, ok := tree.Get(key + 1); ok && value != key+1 {
+					t.Errorf("Get(%d) = %d", key+1, value)
+					return
+				}
+				next, prev := key, -1
+				for k, v := range tree.Range(key, key+200) {
+					if k <= prev || k != v {
+						t.Errorf("Range gave %d => %d after %d", k, v, prev)
+						return
+					}
+					if k&1 == 0 {
+						if k != next {
+							t.Errorf("Range(%d, %d) skipped %d", key, key+200, next)
+							return
+						}
+						next += 2
+					}
+					prev = k
+				}
+				if want := min(key+202, keys); next != want {
+					t.Errorf("Range(%d, %d) stopped before %d", key, key+200, next)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(done)
+	rg.Wait()
+}
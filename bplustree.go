@@ -10,6 +10,10 @@ const (
 	BPLUS_TREE_NON_LEAF = 1
 	BORROW_FROM_LEFT    = 0
 	BORROW_FROM_RIGHT   = 1
+	RANGE_EXCLUDE_MIN   = 1 << 0
+	RANGE_EXCLUDE_MAX   = 1 << 1
+	RANGE_NO_MIN        = 1 << 2
+	RANGE_NO_MAX        = 1 << 3
 )
 
 const DEBUG bool = true
@@ -20,88 +24,94 @@ func assert(scalar bool, ln int) {
 	}
 }
 
-func tern(cond bool, r1, r2 interface{}) interface{} {
-	if cond {
-		return r1
-	}
-	return r2
-}
-
-func key_binary_search(arr []int, length int, target int) int {
+func key_binary_search[K any](arr []K, length int, target K, compare func(a, b K) int) int {
 	low, high := -1, length
 	for low+1 < high {
 		mid := low + (high-low)/2
-		if target > arr[mid] {
+		if compare(target, arr[mid]) > 0 {
 			low = mid
 		} else {
 			high = mid
 		}
 	}
-	if high >= length || arr[high] != target {
+	if high >= length || compare(arr[high], target) != 0 {
 		return -high - 1
 	}
 	return high
 }
 
-func non_leaf_new() *bplus_non_leaf {
-	return &bplus_non_leaf{
-		kind: BPLUS_TREE_NON_LEAF,
+func non_leaf_new[K, V any](tree *bplus_tree[K, V]) *bplus_non_leaf[K, V] {
+	return &bplus_non_leaf[K, V]{
+		kind:    BPLUS_TREE_NON_LEAF,
+		gen:     tree.gen,
+		key:     make([]K, tree.order-1),
+		sub_ptr: make([]bplus_node[K, V], tree.order),
 	}
 }
 
-func leaf_new() *bplus_leaf {
-	return &bplus_leaf{
+func leaf_new[K, V any](tree *bplus_tree[K, V]) *bplus_leaf[K, V] {
+	return &bplus_leaf[K, V]{
 		kind: BPLUS_TREE_LEAF,
+		gen:  tree.gen,
+		key:  make([]K, tree.entries),
+		data: make([]V, tree.entries),
 	}
 }
 
-func non_leaf_delete(node *bplus_non_leaf) {
-	//free(node);
-	node = nil
+func non_leaf_index[K, V any](tree *bplus_tree[K, V], node *bplus_non_leaf[K, V], key K) int {
+
+	var i int = key_binary_search(node.key, node.children-1, key, tree.compare)
+	if i >= 0 {
+		return i + 1
+	}
+	return -i - 1
 }
 
-func leaf_delete(node *bplus_leaf) {
-	//free(node);
-	node = nil
+func non_leaf_locate[K, V any](tree *bplus_tree[K, V], node *bplus_non_leaf[K, V], key K) bplus_node[K, V] {
+	return node.sub_ptr[non_leaf_index(tree, node, key)]
 }
 
-func bplus_tree_search(tree *bplus_tree, key int) int {
+func bplus_tree_locate[K, V any](tree *bplus_tree[K, V], key K) *bplus_leaf[K, V] {
 
-	var node *bplus_node = tree.root
+	var node bplus_node[K, V] = tree.root
 
 	for node != nil {
-		switch (*node).getKind() {
+		switch node.getKind() {
 		case BPLUS_TREE_NON_LEAF:
-			nln := (*node).(*bplus_non_leaf)
-			i := key_binary_search(nln.key[:], nln.children-1, key)
-			if i >= 0 {
-				node = nln.sub_ptr[i+1]
-			} else {
-				i = -i - 1
-				node = nln.sub_ptr[i]
-			}
+			node = non_leaf_locate(tree, node.(*bplus_non_leaf[K, V]), key)
 		case BPLUS_TREE_LEAF:
-			ln := (*node).(*bplus_leaf)
-			i := key_binary_search(ln.key[:], ln.entries, key)
-			if i >= 0 {
-				return ln.data[i]
-			}
-			return 0 // NOTE: what does a zero return value indicate??
+			return node.(*bplus_leaf[K, V])
 		default:
 			assert(false, 80)
-			//log.Println("bplustree.go bplus_tree_search hit default")
+			//log.Println("bplustree.go bplus_tree_locate hit default")
 		}
 	}
-	return 0 // NOTE: what does a zero return value indicate??
+	return nil
 }
 
-func non_leaf_insert(tree *bplus_tree, node *bplus_non_leaf, sub_node *bplus_node, key int, level int) int {
+func bplus_tree_search[K, V any](tree *bplus_tree[K, V], key K) (V, bool) {
 
-	var i, j, split_key int
+	var ln *bplus_leaf[K, V] = bplus_tree_locate_shared(tree, key)
+
+	if ln != nil {
+		defer latch_runlock(tree, &ln.latch)
+		i := key_binary_search(ln.key, ln.entries, key, tree.compare)
+		if i >= 0 {
+			return ln.data[i], true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+func non_leaf_insert[K, V any](tree *bplus_tree[K, V], node *bplus_non_leaf[K, V], sub_node bplus_node[K, V], key K, level int) error {
+
+	var i, j int
+	var split_key K
 	var split int = 0
-	var sibling *bplus_non_leaf
+	var sibling *bplus_non_leaf[K, V]
 
-	var insert int = key_binary_search(node.key[:], node.children-1, key)
+	var insert int = key_binary_search(node.key, node.children-1, key, tree.compare)
 	assert(insert < 0, 95)
 	insert = -insert - 1
 
@@ -110,7 +120,7 @@ func non_leaf_insert(tree *bplus_tree, node *bplus_non_leaf, sub_node *bplus_nod
 		/* split = [m/2] */
 		split = (tree.order + 1) / 2
 		/* splited sibling node */
-		sibling = non_leaf_new()
+		sibling = non_leaf_new(tree)
 		sibling.next = node.next
 		node.next = sibling
 		/* non-leaf node's children always equals to split + 1 after insertion */
@@ -120,12 +130,12 @@ func non_leaf_insert(tree *bplus_tree, node *bplus_non_leaf, sub_node *bplus_nod
 			split_key = node.key[split-1]
 			/* sibling node's first sub-node */
 			sibling.sub_ptr[0] = node.sub_ptr[split]
-			node.sub_ptr[split].parent = sibling
+			node.sub_ptr[split].setParent(sibling)
 			/* insertion point is before split point, replicate from key[split] */
 			for i, j = split, 0; i < tree.order-1; i, j = i+1, j+1 {
 				sibling.key[j] = node.key[i]
 				sibling.sub_ptr[j+1] = node.sub_ptr[i+1]
-				node.sub_ptr[i+1].parent = sibling
+				node.sub_ptr[i+1].setParent(sibling)
 			}
 			sibling.children = j + 1
 			/* insert new key and sub-node */
@@ -135,30 +145,30 @@ func non_leaf_insert(tree *bplus_tree, node *bplus_non_leaf, sub_node *bplus_nod
 			}
 			node.key[i] = key
 			node.sub_ptr[i+1] = sub_node
-			sub_node.parent = node
+			sub_node.setParent(node)
 		} else if insert == split {
 			split_key = key
 			/* sibling node's first sub-node */
 			sibling.sub_ptr[0] = sub_node
-			sub_node.parent = sibling
+			sub_node.setParent(sibling)
 			/* insertion point is split point, replicate from key[split] */
 			for i, j = split, 0; i < tree.order-1; i, j = i+1, j+1 {
 				sibling.key[j] = node.key[i]
 				sibling.sub_ptr[j+1] = node.sub_ptr[i+1]
-				node.sub_ptr[i+1].parent = sibling
+				node.sub_ptr[i+1].setParent(sibling)
 			}
 			sibling.children = j + 1
 		} else {
 			split_key = node.key[split]
 			/* sibling node's first sub-node */
 			sibling.sub_ptr[0] = node.sub_ptr[split+1]
-			node.sub_ptr[split+1].parent = sibling
+			node.sub_ptr[split+1].setParent(sibling)
 			/* insertion point is after split point, replicate from key[split + 1] */
 			for i, j = split+1, 0; i < tree.order-1; j++ {
 				if j != insert-split-1 {
 					sibling.key[j] = node.key[i]
 					sibling.sub_ptr[j+1] = node.sub_ptr[i+1]
-					node.sub_ptr[i+1].parent = sibling
+					node.sub_ptr[i+1].setParent(sibling)
 					i++
 				}
 			}
@@ -173,7 +183,7 @@ func non_leaf_insert(tree *bplus_tree, node *bplus_non_leaf, sub_node *bplus_nod
 			j = insert - split - 1
 			sibling.key[j] = key
 			sibling.sub_ptr[j+1] = sub_node
-			sub_node.parent = sibling
+			sub_node.setParent(sibling)
 		}
 	} else {
 		/* simple insertion */
@@ -185,45 +195,40 @@ func non_leaf_insert(tree *bplus_tree, node *bplus_non_leaf, sub_node *bplus_nod
 		node.sub_ptr[i+1] = sub_node
 		node.children++
 	}
-	if split { // NOTE: split is an int; in C the int's 0 and 1 can also be looked at as booleans
-		var parent *bplus_non_leaf = node.parent
+	if split > 0 {
+		var parent *bplus_non_leaf[K, V] = node.parent
 		if parent == nil {
-			// used to be ++level, changed it to level+1
-			if level+1 >= tree.level {
-				panic("!!Level exceeded, please expand the tree level, non-leaf order or leaf entries for element capacity!\n")
-				node.next = sibling.next
-				non_leaf_delete(sibling)
-				return -1 // NOTE: may not need??
-			}
+			level++
+			assert(level == len(tree.head), 199)
 			/* new parent */
-			parent = non_leaf_new()
+			parent = non_leaf_new(tree)
 			parent.key[0] = split_key
-			parent.sub_ptr[0] = node.(*bplus_node)
-			parent.sub_ptr[1] = sibling.(*bplus_node)
+			parent.sub_ptr[0] = node
+			parent.sub_ptr[1] = sibling
 			parent.children = 2
 			/* update root */
-			tree.root = parent.(*bplus_node)
-			tree.head[level] = parent.(*bplus_node)
+			tree.root = parent
+			tree.head = append(tree.head, parent)
 			node.parent = parent
 			sibling.parent = parent
 		} else {
 			/* Trace upwards */
 			sibling.parent = parent
-			return non_leaf_insert(tree, parent, sibling.(*bplus_node), split_key, level+1)
+			return non_leaf_insert(tree, parent, sibling, split_key, level+1)
 		}
 	}
-	return 0 // NOTE: what is this return value even used for??
+	return nil
 }
 
-func leaf_insert(tree *bplus_tree, leaf *bplus_leaf, key int, data int) int {
+func leaf_insert[K, V any](tree *bplus_tree[K, V], leaf *bplus_leaf[K, V], key K, data V) error {
 
-	var i, j, split int = 0
-	var sibling *bplus_leaf
+	var i, j, split int
+	var sibling *bplus_leaf[K, V]
 
-	var insert int = key_binary_search(leaf.key, leaf.entries, key)
+	var insert int = key_binary_search(leaf.key, leaf.entries, key, tree.compare)
 	if insert >= 0 {
 		/* Already exists */
-		return -1 // NOTE: ??
+		return ErrKeyExists
 	}
 	insert = -insert - 1
 
@@ -232,8 +237,16 @@ func leaf_insert(tree *bplus_tree, leaf *bplus_leaf, key int, data int) int {
 		/* split = [m/2] */
 		split = (tree.entries + 1) / 2
 		/* splited sibling node */
-		sibling = leaf_new()
+		sibling = leaf_new(tree)
+		latch_lock(tree, &sibling.latch)
+		defer latch_unlock(tree, &sibling.latch)
 		sibling.next = leaf.next
+		sibling.prev = leaf
+		if leaf.next != nil {
+			latch_lock(tree, &leaf.next.latch)
+			leaf.next.prev = sibling
+			latch_unlock(tree, &leaf.next.latch)
+		}
 		leaf.next = sibling
 		/* leaf node's entries always equals to split after insertion */
 		leaf.entries = split
@@ -284,95 +297,113 @@ func leaf_insert(tree *bplus_tree, leaf *bplus_leaf, key int, data int) int {
 		leaf.entries++
 	}
 
-	if split {
-		var parent *bplus_non_leaf = leaf.parent
+	if split > 0 {
+		var parent *bplus_non_leaf[K, V] = leaf.parent
 		if parent == nil {
 			/* new parent */
-			parent = non_leaf_new()
+			parent = non_leaf_new(tree)
 			parent.key[0] = sibling.key[0]
-			parent.sub_ptr[0] = leaf.(*bplus_node)
-			parent.sub_ptr[1] = sibling.(*bplus_node)
+			parent.sub_ptr[0] = leaf
+			parent.sub_ptr[1] = sibling
 			parent.children = 2
 			/* update root */
-			tree.root = parent.(*bplus_node)
-			tree.head[1] = parent.(*bplus_node)
+			tree.root = parent
+			tree.head = append(tree.head, parent)
 			leaf.parent = parent
 			sibling.parent = parent
 		} else {
 			/* trace upwards */
 			sibling.parent = parent
-			return non_leaf_insert(tree, parent, sibling.(*bplus_node), sibling.key[0], 1) // NOTE: what does this return??
+			return non_leaf_insert(tree, parent, sibling, sibling.key[0], 1)
 		}
 	}
-	return 0 //NOTE: ??
+	return nil
 }
 
-func bplus_tree_insert(tree *bplus_tree, key int, data int) int {
+func leaf_replace[K, V any](tree *bplus_tree[K, V], leaf *bplus_leaf[K, V], key K, data V) (V, error) {
 
-	var node *bplus_node = tree.root
+	var i int = key_binary_search(leaf.key, leaf.entries, key, tree.compare)
+	if i < 0 {
+		/* Not exist */
+		var zero V
+		return zero, ErrNotFound
+	}
 
-	for node != nil {
-		switch node.getKind() {
-		case BPLUS_TREE_NON_LEAF:
-			nln := node.(*bplus_non_leaf)
-			i := key_binary_search(nln.key, nln.children-1, key)
-			if i >= 0 {
-				node = nln.sub_ptr[i+1]
-			} else {
-				i = -i - 1
-				node = nln.sub_ptr[i]
-			}
-		case BPLUS_TREE_LEAF:
-			ln := node.(*bplus_leaf)
-			return leaf_insert(tree, ln, key, data)
-		default:
-			assert(false, 320)
-			//log.Println("bplustree.go bplus_tree_insert hit default")
-		}
+	/* replace in place, the tree shape is unchanged */
+	var old V = leaf.data[i]
+	leaf.data[i] = data
+	return old, nil
+}
+
+func bplus_tree_insert[K, V any](tree *bplus_tree[K, V], key K, data V) error {
+
+	var path crab_path
+	defer crab_release(tree, &path)
+	var ln *bplus_leaf[K, V] = bplus_tree_locate_exclusive(tree, key, CRAB_INSERT, &path)
+	if ln != nil {
+		ln = leaf_cow(tree, ln, nil)
+	}
+
+	if ln != nil {
+		return leaf_insert(tree, ln, key, data)
 	}
 
+	leaf_insert_root(tree, key, data)
+	return nil
+}
+
+func leaf_insert_root[K, V any](tree *bplus_tree[K, V], key K, data V) {
 	/* new root */
-	root := leaf_new()
+	root := leaf_new(tree)
 	root.key[0] = key
 	root.data[0] = data
 	root.entries = 1
 
-	tree.head[0] = root.(*bplus_node)
-	tree.root = root.(*bplus_node)
-	return 0
+	tree.head = append(tree.head[:0], root)
+	tree.root = root
 }
 
-func non_leaf_remove(tree *bplus_tree, node *bplus_non_leaf, remove int, level int) {
+func non_leaf_remove[K, V any](tree *bplus_tree[K, V], node *bplus_non_leaf[K, V], remove int, level int) {
 
 	var i, j, k int
-	var sibling *bplus_non_leaf
+	var sibling *bplus_non_leaf[K, V]
 
 	if node.children <= (tree.order+1)/2 {
-		var parent *bplus_non_leaf = node.parent
+		var parent *bplus_non_leaf[K, V] = node.parent
 		if parent != nil {
 			var borrow int = 0
 			/* find which sibling node with same parent to be borrowed from */
-			i = key_binary_search(parent.key, parent.children-1, node.key[0])
+			i = key_binary_search(parent.key, parent.children-1, node.key[0], tree.compare)
 			assert((i < 0), 346)
 			i = -i - 1
 			if i == 0 {
 				/* no left sibling, choose right one */
-				sibling = parent.sub_ptr[i+1].(*bplus_non_leaf)
+				sibling = parent.sub_ptr[i+1].(*bplus_non_leaf[K, V])
+				latch_lock(tree, &sibling.latch)
 				borrow = BORROW_FROM_RIGHT
 			} else if i == parent.children-1 {
 				/* no right sibling, choose left one */
-				sibling = parent.sub_prt[i-1].(*bplus_non_leaf)
+				sibling = parent.sub_ptr[i-1].(*bplus_non_leaf[K, V])
+				latch_lock(tree, &sibling.latch)
 				borrow = BORROW_FROM_LEFT
 			} else {
-				var l_sib *bplus_non_leaf = parent.sub_ptr[i-1].(*bplus_non_leaf)
-				var r_sib *bplus_non_leaf = parent.sub_ptr[i+1].(*bplus_non_leaf)
+				var l_sib *bplus_non_leaf[K, V] = parent.sub_ptr[i-1].(*bplus_non_leaf[K, V])
+				var r_sib *bplus_non_leaf[K, V] = parent.sub_ptr[i+1].(*bplus_non_leaf[K, V])
+				latch_lock(tree, &l_sib.latch)
+				latch_lock(tree, &r_sib.latch)
 				/* if both left and right sibling found, choose the one with more children */
-
-				// NOTE: using a home grown ternary function here to compensate for the fact that go
-				// does not have one. don't know at current time if this will result in poor performance.
-				sibling = tern(l_sib.children >= r.sib.children, l_sib, r_sib)
-				borrow = tern(l_sib.children >= r.sib.children, BORROW_FROM_LEFT, BORROW_FROM_RIGHT)
+				if l_sib.children >= r_sib.children {
+					sibling = l_sib
+					borrow = BORROW_FROM_LEFT
+					latch_unlock(tree, &r_sib.latch)
+				} else {
+					sibling = r_sib
+					borrow = BORROW_FROM_RIGHT
+					latch_unlock(tree, &l_sib.latch)
+				}
 			}
+			defer latch_unlock(tree, &sibling.latch)
+			sibling = non_leaf_cow(tree, sibling)
 
 			/* locate parent node key to update later */
 			i = i - 1
@@ -391,7 +422,7 @@ func non_leaf_remove(tree *bplus_tree, node *bplus_non_leaf, remove int, level i
 					parent.key[i] = sibling.key[sibling.children-2]
 					/* borrow the last sub-node from left sibling */
 					node.sub_ptr[0] = sibling.sub_ptr[sibling.children-1]
-					sibling.sub_ptr[sibling.children-1].parent = node
+					sibling.sub_ptr[sibling.children-1].setParent(node)
 					sibling.children--
 				} else {
 					/* move parent key down */
@@ -406,14 +437,13 @@ func non_leaf_remove(tree *bplus_tree, node *bplus_non_leaf, remove int, level i
 					for j, k = sibling.children, 0; k < node.children; k++ {
 						if k != remove+1 {
 							sibling.sub_ptr[j] = node.sub_ptr[k]
-							node.sub_ptr[k].parent = sibling
+							node.sub_ptr[k].setParent(sibling)
 							j++
 						}
 					}
 					sibling.children = j
 					/* delete merged node */
 					sibling.next = node.next
-					non_leaf_delete(node)
 					/* trace upwards */
 					non_leaf_remove(tree, parent, i, level+1)
 				}
@@ -431,7 +461,7 @@ func non_leaf_remove(tree *bplus_tree, node *bplus_non_leaf, remove int, level i
 					parent.key[i+1] = sibling.key[0]
 					/* borrow the frist sub-node from right sibling */
 					node.sub_ptr[node.children] = sibling.sub_ptr[0]
-					sibling.sub_ptr[0].parent = node
+					sibling.sub_ptr[0].setParent(node)
 					node.children++
 					/* left shift in right sibling */
 					for j = 0; j < sibling.children-2; j++ {
@@ -451,27 +481,27 @@ func non_leaf_remove(tree *bplus_tree, node *bplus_non_leaf, remove int, level i
 					}
 					for j, k = node.children-1, 0; k < sibling.children; j, k = j+1, k+1 {
 						node.sub_ptr[j] = sibling.sub_ptr[k]
-						sibling.sub_ptr[k].parent = node
+						sibling.sub_ptr[k].setParent(node)
 					}
 					node.children = j
 					/* delete merged sibling */
 					node.next = sibling.next
-					non_leaf_delete(sibling)
 					/* trace upwards */
 					non_leaf_remove(tree, parent, i+1, level+1)
 				}
 			}
 			/* deletion finishes */
-			return // NOTE: not sure what this means in the long and short??
+			return
 		} else {
 			if node.children == 2 {
 				/* delete old root node */
 				assert(remove == 0, 467)
-				node.sub_ptr[0].parent = nil
+				node.sub_ptr[0].setParent(nil)
 				tree.root = node.sub_ptr[0]
+				assert(level == len(tree.head)-1, 481)
 				tree.head[level] = nil
-				non_leaf_delete(node)
-				return // NOTE: again, not sure about this empty return... or what it means for go translation...
+				tree.head = tree.head[:level]
+				return
 			}
 		}
 	}
@@ -486,62 +516,81 @@ func non_leaf_remove(tree *bplus_tree, node *bplus_non_leaf, remove int, level i
 	node.children--
 }
 
-func leaf_remove(tree *bplus_tree, leaf *bplus_leaf, key int) int {
+func leaf_remove[K, V any](tree *bplus_tree[K, V], leaf *bplus_leaf[K, V], key K) (V, error) {
 
 	var i, j, k int
-	var sibling *bplus_leaf
+	var sibling *bplus_leaf[K, V]
 
-	var remove int = key_binary_search(leaf.key, leaf.entries, key)
+	var remove int = key_binary_search(leaf.key, leaf.entries, key, tree.compare)
 	if remove < 0 {
 		/* Not exist */
-		return -1 // NOTE: whatever this means...
+		var zero V
+		return zero, ErrNotFound
 	}
+	var data V = leaf.data[remove]
 
 	if leaf.entries <= (tree.entries+1)/2 {
-		var parent *bplus_non_leaf = leaf.parent
+		var parent *bplus_non_leaf[K, V] = leaf.parent
 		if parent != nil {
 			var borrow int = 0
 			/* find which sibling node with same parent to be borrowed from */
-			i = key_binary_search(parent.key, parent.children-1, leaf.key[0])
+			i = key_binary_search(parent.key, parent.children-1, leaf.key[0], tree.compare)
 			if i >= 0 {
 				i = i + 1
 				if i == parent.children-1 {
 					/* the last node, no right sibling, choose left one */
-					sibling = parent.sub_ptr[i-1].(*bplus_leaf)
+					sibling = parent.sub_ptr[i-1].(*bplus_leaf[K, V])
+					latch_lock(tree, &sibling.latch)
 					borrow = BORROW_FROM_LEFT
 				} else {
-					var l_sib *bplus_leaf = parent.sub_ptr[i-1].(*bplus_leaf)
-					var r_sib *bplus_leaf = parent.sub_ptr[i+1].(*bplus_leaf)
+					var l_sib *bplus_leaf[K, V] = parent.sub_ptr[i-1].(*bplus_leaf[K, V])
+					var r_sib *bplus_leaf[K, V] = parent.sub_ptr[i+1].(*bplus_leaf[K, V])
+					latch_lock(tree, &l_sib.latch)
+					latch_lock(tree, &r_sib.latch)
 					/* if both left and right sibling found, choose the one with more entries */
-
-					// NOTE: again, used my home grown ternary function, don't knwo if this is good or
-					// bad, just mentioning it in case it it bad, then this portion can be re-written...
-					sibling = tern(l_sib.entries >= r_sib.entries, l_sib, r_sib)
-					borrow = tern(l_sib.entries >= r_sib.entries, BORROW_FROM_LEFT, BORROW_FROM_RIGHT)
+					if l_sib.entries >= r_sib.entries {
+						sibling = l_sib
+						borrow = BORROW_FROM_LEFT
+						latch_unlock(tree, &r_sib.latch)
+					} else {
+						sibling = r_sib
+						borrow = BORROW_FROM_RIGHT
+						latch_unlock(tree, &l_sib.latch)
+					}
 				}
 			} else {
 				i = -i - 1
 				if i == 0 {
 					/* the frist node, no left sibling, choose right one */
-					sibling = parent.sub_ptr[i+1].(*bplus_leaf)
+					sibling = parent.sub_ptr[i+1].(*bplus_leaf[K, V])
+					latch_lock(tree, &sibling.latch)
 					borrow = BORROW_FROM_RIGHT
 				} else if i == parent.children-1 {
 					/* the last node, no right sibling, choose left one */
-					sibling = parent.sub_ptr[i-1].(*bplus_leaf)
+					sibling = parent.sub_ptr[i-1].(*bplus_leaf[K, V])
+					latch_lock(tree, &sibling.latch)
 					borrow = BORROW_FROM_LEFT
 				} else {
-					var l_sib *bplus_leaf = parent.sub_ptr[i-1].(*bplus_leaf)
-					var r_sib *bplus_leaf = parent.sub_ptr[i+1].(*bplus_leaf)
+					var l_sib *bplus_leaf[K, V] = parent.sub_ptr[i-1].(*bplus_leaf[K, V])
+					var r_sib *bplus_leaf[K, V] = parent.sub_ptr[i+1].(*bplus_leaf[K, V])
+					latch_lock(tree, &l_sib.latch)
+					latch_lock(tree, &r_sib.latch)
 					/* if both left and right sibling found, choose the one with more entries */
-
-					// NOTE: again, used my home grown ternary function, don't knwo if this is good or
-					// bad, just mentioning it in case it it bad, then this portion can be re-written...
-					sibling = tern(l_sib.entries >= r_sib.entries, l_sib, r_sib)
-					borrow = tern(l_sib.entries >= r_sib.entries, BORROW_FROM_LEFT, BORROW_FROM_RIGHT)
-
+					if l_sib.entries >= r_sib.entries {
+						sibling = l_sib
+						borrow = BORROW_FROM_LEFT
+						latch_unlock(tree, &r_sib.latch)
+					} else {
+						sibling = r_sib
+						borrow = BORROW_FROM_RIGHT
+						latch_unlock(tree, &l_sib.latch)
+					}
 				}
 			}
 
+			defer latch_unlock(tree, &sibling.latch)
+			sibling = leaf_cow(tree, sibling, leaf)
+
 			/* locate parent node key to update later */
 			i = i - 1
 
@@ -571,7 +620,11 @@ func leaf_remove(tree *bplus_tree, leaf *bplus_leaf, key int) int {
 					sibling.entries = j
 					/* delete merged leaf */
 					sibling.next = leaf.next
-					leaf_delete(leaf)
+					if leaf.next != nil {
+						latch_lock(tree, &leaf.next.latch)
+						leaf.next.prev = sibling
+						latch_unlock(tree, &leaf.next.latch)
+					}
 					/* trace upwards */
 					non_leaf_remove(tree, parent, i, 1)
 				}
@@ -605,21 +658,25 @@ func leaf_remove(tree *bplus_tree, leaf *bplus_leaf, key int) int {
 					leaf.entries = j
 					/* delete right sibling */
 					leaf.next = sibling.next
-					leaf_delete(sibling)
+					if sibling.next != nil {
+						latch_lock(tree, &sibling.next.latch)
+						sibling.next.prev = leaf
+						latch_unlock(tree, &sibling.next.latch)
+					}
 					/* trace upwards */
 					non_leaf_remove(tree, parent, i+1, 1)
 				}
 			}
 			/* deletion finishes */
-			return 0
+			return data, nil
 		} else {
 			if leaf.entries == 1 {
 				/* delete the only last node */
-				assert(key == leaf.key[0])
+				assert(tree.compare(key, leaf.key[0]) == 0, 618)
 				tree.root = nil
 				tree.head[0] = nil
-				leaf_delete(leaf)
-				return 0
+				tree.head = tree.head[:0]
+				return data, nil
 			}
 		}
 	}
@@ -632,48 +689,38 @@ func leaf_remove(tree *bplus_tree, leaf *bplus_leaf, key int) int {
 	}
 	leaf.entries--
 
-	return 0
+	return data, nil
 }
 
-func bplus_tree_delete(tree *bplus_tree, key int) int {
+func bplus_tree_delete[K, V any](tree *bplus_tree[K, V], key K) (V, error) {
 
-	var node *bplus_node = tree.root
+	var path crab_path
+	defer crab_release(tree, &path)
+	var ln *bplus_leaf[K, V] = bplus_tree_locate_exclusive(tree, key, CRAB_DELETE, &path)
+	if ln != nil {
+		ln = leaf_cow(tree, ln, nil)
+	}
 
-	for node != nil {
-		switch node.kind {
-		case BPLUS_TREE_NON_LEAF:
-			nln := node.(*bplus_non_leaf)
-			i := key_binary_search(nln.key, nln.children-1, key)
-			if i >= 0 {
-				node = nln.sub_ptr[i+1]
-			} else {
-				i = -i - 1
-				node = nln.sub_ptr[i]
-			}
-		case BPLUS_TREE_LEAF:
-			ln := node.(*bplus_leaf)
-			return leaf_remove(tree, ln, key)
-		default:
-			// was assert(0)
-			assert(false, 659)
-		}
+	if ln != nil {
+		return leaf_remove(tree, ln, key)
 	}
 
-	return -1 // NOTE: again, don't know what this return value actually signifies
+	var zero V
+	return zero, ErrNotFound
 }
 
-func bplus_tree_dump(tree *bplus_tree) {
+func bplus_tree_dump[K, V any](tree *bplus_tree[K, V]) {
 
 	var i, j int
 
-	for i = tree.level - 1; i > 0; i-- {
-		var node *bplus_non_leaf = tree.head[i].(*bplus_non_leaf)
+	for i = len(tree.head) - 1; i > 0; i-- {
+		var node, _ = tree.head[i].(*bplus_non_leaf[K, V])
 		if node != nil {
 			fmt.Printf("LEVEL %d:\n", i)
 			for node != nil {
 				fmt.Printf("node: ")
 				for j = 0; j < node.children-1; j++ {
-					fmt.Printf("%d ", node.key[j])
+					fmt.Printf("%v ", node.key[j])
 				}
 				fmt.Printf("\n")
 				node = node.next
@@ -681,109 +728,236 @@ func bplus_tree_dump(tree *bplus_tree) {
 		}
 	}
 
-	var leaf *bplus_leaf = tree.head[0].(*bplus_leaf)
+	var leaf *bplus_leaf[K, V] = bplus_tree_first_leaf(tree)
 	if leaf != nil {
 		fmt.Printf("LEVEL 0:\n")
 		for leaf != nil {
 			fmt.Printf("leaf: ")
 			for j = 0; j < leaf.entries; j++ {
-				fmt.Printf("%d ", leaf.key[j])
+				fmt.Printf("%v ", leaf.key[j])
 			}
-			printf("\n")
+			fmt.Printf("\n")
 			leaf = leaf.next
 		}
 	} else {
-		printf("Empty tree!\n")
+		fmt.Printf("Empty tree!\n")
 	}
 }
 
-func bplus_tree_get(tree *bplus_tree, key int) int {
-	var data int = bplus_tree_search(tree, key)
-	if data >= 0 {
-		return data
-	}
-	return -1
+func bplus_tree_get[K, V any](tree *bplus_tree[K, V], key K) (V, bool) {
+	return bplus_tree_search(tree, key)
 }
 
-func bplus_tree_put(tree *bplus_tree, key int, data int) int {
-	if data >= 0 {
-		return bplus_tree_insert(tree, key, data)
-	}
-	return bplus_tree_delete(tree, key)
-}
+func bplus_tree_replace[K, V any](tree *bplus_tree[K, V], key K, data V) (V, error) {
 
-func bplus_tree_init(level int, order int, entries int) *bplus_tree {
-	/* The max order of non leaf nodes must be more than two */
-	assert(MAX_ORDER > MIN_ORDER, 715)
-	assert(level <= MAX_LEVEL && order <= MAX_ORDER && entries <= MAX_ENTRIES, 716)
+	var path crab_path
+	defer crab_release(tree, &path)
+	var ln *bplus_leaf[K, V] = bplus_tree_locate_exclusive(tree, key, CRAB_UPDATE, &path)
+	if ln != nil {
+		ln = leaf_cow(tree, ln, nil)
+	}
 
-	var tree *bplus_tree = make(*tree, 0)
-	if tree != nil {
-		tree.root = nil
-		tree.level = level
-		tree.order = order
-		tree.entries = entries
-		//memset(tree.head, 0, MAX_LEVEL * sizeof(struct bplus_node *));
+	if ln != nil {
+		return leaf_replace(tree, ln, key, data)
 	}
 
-	return tree
+	var zero V
+	return zero, ErrNotFound
 }
 
-func bplus_tree_deinit(tree *bplus_tree) {
-	//free(tree);
-	tree = nil
+func bplus_tree_compare_and_swap[K, V any](tree *bplus_tree[K, V], key K, old V, data V) bool {
+
+	var path crab_path
+	defer crab_release(tree, &path)
+	var ln *bplus_leaf[K, V] = bplus_tree_locate_exclusive(tree, key, CRAB_UPDATE, &path)
+	if ln != nil {
+		ln = leaf_cow(tree, ln, nil)
+	}
+
+	if ln != nil {
+		i := key_binary_search(ln.key, ln.entries, key, tree.compare)
+		if i >= 0 && any(ln.data[i]) == any(old) {
+			ln.data[i] = data
+			return true
+		}
+	}
+	return false
 }
 
-func bplus_tree_get_range(tree *bplus_tree, key1 int, key2 int) int {
+func bplus_tree_put[K, V any](tree *bplus_tree[K, V], key K, data V) (V, bool, error) {
 
-	var data, min, max int = 0
+	var path crab_path
+	defer crab_release(tree, &path)
+	var ln *bplus_leaf[K, V] = bplus_tree_locate_exclusive(tree, key, CRAB_INSERT, &path)
+	if ln != nil {
+		ln = leaf_cow(tree, ln, nil)
+	}
+	var zero V
 
-	if key1 <= key2 {
-		min = key1
+	if ln != nil {
+		if old, err := leaf_replace(tree, ln, key, data); err == nil {
+			return old, true, nil
+		}
+		if err := leaf_insert(tree, ln, key, data); err != nil {
+			return zero, false, err
+		}
 	} else {
-		min = key2
+		leaf_insert_root(tree, key, data)
 	}
 
-	if min == key1 {
-		max = key2
-	} else {
-		max = key1
+	return zero, false, nil
+}
+
+func bplus_tree_init[K, V any](order int, entries int, compare func(a, b K) int) *bplus_tree[K, V] {
+	/* The order of non leaf nodes must be more than three */
+	assert(order > MIN_ORDER && entries >= MIN_ORDER, 716)
+
+	return &bplus_tree[K, V]{
+		order:   order,
+		entries: entries,
+		compare: compare,
+	}
+}
+
+func bplus_tree_first_leaf[K, V any](tree *bplus_tree[K, V]) *bplus_leaf[K, V] {
+	if len(tree.head) == 0 {
+		return nil
 	}
+	return tree.head[0].(*bplus_leaf[K, V])
+}
 
-	var node *bplus_node = tree.root
+func bplus_tree_last_leaf[K, V any](tree *bplus_tree[K, V]) *bplus_leaf[K, V] {
+
+	var node bplus_node[K, V] = tree.root
 
 	for node != nil {
 		switch node.getKind() {
 		case BPLUS_TREE_NON_LEAF:
-			nln := node.(*bplus_non_leaf)
-			i := key_binary_search(nln.key, nln.children-1, min)
-			if i >= 0 {
-				node = nln.sub_ptr[i+1]
-			} else {
-				i = -i - 1
-				node = nln.sub_ptr[i]
-			}
+			nln := node.(*bplus_non_leaf[K, V])
+			node = nln.sub_ptr[nln.children-1]
 		case BPLUS_TREE_LEAF:
-			ln := node.(*bplus_leaf)
-			i := key_binary_search(ln.key, ln.entries, min)
-			if i < 0 {
-				i = -i - 1
-				if i >= ln.entries {
-					ln = ln.next
+			return node.(*bplus_leaf[K, V])
+		default:
+			assert(false, 770)
+		}
+	}
+	return nil
+}
+
+func bplus_tree_get_range[K, V any](tree *bplus_tree[K, V], min K, max K, flags int, fn func(key K, data V) bool) {
+
+	var ln, next *bplus_leaf[K, V]
+	var i int
+
+restart:
+	if flags&RANGE_NO_MIN != 0 {
+		ln = bplus_tree_first_leaf_shared(tree)
+		i = 0
+	} else {
+		ln = bplus_tree_locate_shared(tree, min)
+		if ln == nil {
+			return
+		}
+		i = key_binary_search(ln.key, ln.entries, min, tree.compare)
+		if i < 0 {
+			i = -i - 1
+		} else if flags&RANGE_EXCLUDE_MIN != 0 {
+			i++
+		}
+	}
+	for ln != nil {
+		if i >= ln.entries {
+			/* continue along the leaf chain */
+			next = ln.next
+			if next != nil && !latch_try_rlock(tree, &next.latch) {
+				/* never wait holding a latch, resume past the last key from the root */
+				if flags&RANGE_NO_MIN != 0 || tree.compare(ln.key[i-1], min) >= 0 {
+					min = ln.key[i-1]
+					flags = flags&^RANGE_NO_MIN | RANGE_EXCLUDE_MIN
 				}
+				latch_runlock(tree, &ln.latch)
+				next.latch.RLock()
+				next.latch.RUnlock()
+				goto restart
+			}
+			latch_runlock(tree, &ln.latch)
+			ln = next
+			i = 0
+			continue
+		}
+		if flags&RANGE_NO_MAX == 0 {
+			c := tree.compare(ln.key[i], max)
+			if c > 0 || (c == 0 && flags&RANGE_EXCLUDE_MAX != 0) {
+				break
 			}
-			for ln != nil && ln.key[i] <= max {
-				data = ln.data[i]
-				if i+1 >= ln.entries {
-					ln = ln.next
-					i = 0
+		}
+		if !fn(ln.key[i], ln.data[i]) {
+			break
+		}
+		i++
+	}
+	if ln != nil {
+		latch_runlock(tree, &ln.latch)
+	}
+}
+
+func bplus_tree_get_range_reverse[K, V any](tree *bplus_tree[K, V], max K, min K, flags int, fn func(key K, data V) bool) {
+
+	var ln, prev *bplus_leaf[K, V]
+	var i int
+
+restart:
+	if flags&RANGE_NO_MAX != 0 {
+		ln = bplus_tree_last_leaf_shared(tree)
+		if ln != nil {
+			i = ln.entries - 1
+		}
+	} else {
+		ln = bplus_tree_locate_shared(tree, max)
+		if ln == nil {
+			return
+		}
+		i = key_binary_search(ln.key, ln.entries, max, tree.compare)
+		if i < 0 {
+			i = -i - 2
+		} else if flags&RANGE_EXCLUDE_MAX != 0 {
+			i--
+		}
+	}
+	for ln != nil {
+		if i < 0 {
+			/* continue backwards along the leaf chain */
+			prev = ln.prev
+			if prev != nil && !latch_try_rlock(tree, &prev.latch) {
+				/* never wait holding a latch, resume below the first key from the root */
+				if flags&RANGE_NO_MAX != 0 || tree.compare(ln.key[0], max) <= 0 {
+					max = ln.key[0]
+					flags = flags&^RANGE_NO_MAX | RANGE_EXCLUDE_MAX
 				}
+				latch_runlock(tree, &ln.latch)
+				prev.latch.RLock()
+				prev.latch.RUnlock()
+				goto restart
 			}
-			return data
-		default:
-			//assert(0)
-			assert(false, 784)
+			latch_runlock(tree, &ln.latch)
+			ln = prev
+			if ln != nil {
+				i = ln.entries - 1
+			}
+			continue
 		}
+		if flags&RANGE_NO_MIN == 0 {
+			c := tree.compare(ln.key[i], min)
+			if c < 0 || (c == 0 && flags&RANGE_EXCLUDE_MIN != 0) {
+				break
+			}
+		}
+		if !fn(ln.key[i], ln.data[i]) {
+			break
+		}
+		i--
+	}
+	if ln != nil {
+		latch_runlock(tree, &ln.latch)
 	}
-	return 0
 }
@@ -0,0 +1,271 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+ * Integrity check
+ *
+ * Walk every node of a disk tree depth first, from the root down and
+ * from the left, checking each against the invariants the insertion and
+ * removal code maintains, and collect every broken one rather than stop
+ * at the first. A node that cannot be read, or whose header makes its
+ * slots meaningless, is reported and its subtree skipped. The walk keeps
+ * the last node seen on each level, so the next links are checked
+ * against the order the nodes are reached in; links into or out of a
+ * skipped subtree are unknown and not checked.
+ */
+
+const check_unknown = ^pgid(0)
+
+// A Violation is a broken invariant found by Check, reported against
+// the page that breaks it.
+type Violation struct {
+	PageID  uint64 // the page, 0 for the meta page
+	Problem string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("page %d: %s", v.PageID, v.Problem)
+}
+
+type disk_checker[K, V any] struct {
+	tree  *disk_tree[K, V]
+	seen  []bool
+	last  []pgid /* last node reached on each level */
+	next  []pgid /* and its next link */
+	count uint64
+	lost  bool /* some subtree was skipped */
+	found []Violation
+}
+
+func check_report[K, V any](c *disk_checker[K, V], id pgid, format string, args ...any) {
+	c.found = append(c.found, Violation{PageID: uint64(id), Problem: fmt.Sprintf(format, args...)})
+}
+
+/* give up on the subtree at level, whose links are unknown from here on */
+func check_skip[K, V any](c *disk_checker[K, V], level int) {
+	for l := 1; l <= level; l++ {
+		c.last[l], c.next[l] = check_unknown, check_unknown
+	}
+	c.lost = true
+}
+
+/* check the subtree at page id, the level-th above the leaves, all of whose keys must lie in [lo, hi) */
+func check_node[K, V any](c *disk_checker[K, V], id pgid, parent pgid, level int, lo *K, hi *K) error {
+
+	tree := c.tree
+	if id == 0 || uint64(id) >= tree.meta.npages {
+		check_report(c, parent, "child page %d out of range", id)
+		check_skip(c, level)
+		return nil
+	}
+	if c.seen[id] {
+		check_report(c, parent, "child page %d is linked more than once", id)
+		check_skip(c, level)
+		return nil
+	}
+	c.seen[id] = true
+
+	/* the level's links */
+	if last := c.last[level]; last != 0 && c.next[level] != check_unknown && c.next[level] != id {
+		check_report(c, last, "next is page %d, not page %d", c.next[level], id)
+	}
+
+	page, err := pager_read(&tree.pager, &tree.meta, id)
+	if err != nil {
+		if errors.Is(err, ErrInvalidFile) {
+			check_report(c, id, "%v", err)
+			check_skip(c, level-1)
+			c.last[level], c.next[level] = id, check_unknown
+			return nil
+		}
+		return err
+	}
+	defer pager_unpin(&tree.pager, page)
+
+	if page_parent(page) != parent {
+		check_report(c, id, "parent is page %d, not page %d", page_parent(page), parent)
+	}
+	if last := c.last[level]; level == 1 && last != check_unknown && page_prev(page) != last {
+		check_report(c, id, "prev is page %d, not page %d", page_prev(page), last)
+	}
+	c.last[level], c.next[level] = id, page_next(page)
+
+	count := page_count(page)
+	if level == 1 {
+		if page_kind(page) != BPLUS_TREE_LEAF {
+			check_report(c, id, "kind %d where the tree's height puts its leaves", page_kind(page))
+			c.lost = true
+			return nil
+		}
+		if count > tree.entries {
+			check_report(c, id, "holds %d entries, more than %d", count, tree.entries)
+			c.lost = true
+			return nil
+		}
+		if min := check_min_entries(tree, parent); count < min {
+			check_report(c, id, "holds %d entries, fewer than %d", count, min)
+		}
+		c.count += uint64(count)
+	} else {
+		if page_kind(page) != BPLUS_TREE_NON_LEAF {
+			check_report(c, id, "kind %d at depth %d, above the tree's leaves at depth %d", page_kind(page), tree.meta.height-level+1, tree.meta.height)
+			check_skip(c, level-1)
+			return nil
+		}
+		if count > tree.order {
+			check_report(c, id, "has %d children, more than %d", count, tree.order)
+			check_skip(c, level-1)
+			return nil
+		}
+		if min := check_min_children(tree, parent); count < min {
+			check_report(c, id, "has %d children, fewer than %d", count, min)
+		}
+	}
+
+	/* keys sorted and within the separators above */
+	var keys int = count
+	if level > 1 {
+		keys = count - 1
+	}
+	var prev K
+	for i := 0; i < keys; i++ {
+		key := disk_key(tree, page, i)
+		if i > 0 && tree.compare(prev, key) >= 0 {
+			check_report(c, id, "key %d (%v) is not above key %d (%v)", i, key, i-1, prev)
+		}
+		if lo != nil && tree.compare(key, *lo) < 0 {
+			check_report(c, id, "key %d (%v) is below its separator %v", i, key, *lo)
+		}
+		if hi != nil && tree.compare(key, *hi) >= 0 {
+			check_report(c, id, "key %d (%v) is not below its separator %v", i, key, *hi)
+		}
+		prev = key
+	}
+
+	if level > 1 {
+		for i := 0; i < count; i++ {
+			l, h := lo, hi
+			if i > 0 {
+				key := disk_key(tree, page, i-1)
+				l = &key
+			}
+			if i < count-1 {
+				key := disk_key(tree, page, i)
+				h = &key
+			}
+			if err := check_node(c, disk_sub(tree, page, i), id, level-1, l, h); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * the fewest entries in a leaf below the root: splitting a full leaf
+ * leaves (entries+1)/2 in the left half, and disk_leaf_remove borrows
+ * or merges rather than take a leaf below that
+ */
+func check_min_entries[K, V any](tree *disk_tree[K, V], parent pgid) int {
+	if parent == 0 {
+		return 1
+	}
+	return (tree.entries + 1) / 2
+}
+
+/*
+ * and children in a non-leaf below the root: splitting a full node
+ * leaves order/2 in the new sibling, and disk_non_leaf_remove borrows
+ * or merges before a node has fewer than (order+1)/2
+ */
+func check_min_children[K, V any](tree *disk_tree[K, V], parent pgid) int {
+	if parent == 0 {
+		return 2
+	}
+	return tree.order / 2
+}
+
+/* walk the free list, which must hold nfree free pages no node uses */
+func check_free[K, V any](c *disk_checker[K, V]) error {
+
+	tree := c.tree
+	var n uint64
+	var from pgid
+	for id := tree.meta.free; id != 0; n++ {
+		if uint64(id) >= tree.meta.npages {
+			check_report(c, from, "free list links to page %d, out of range", id)
+			break
+		}
+		if c.seen[id] {
+			check_report(c, id, "on the free list but in use or listed twice")
+			break
+		}
+		c.seen[id] = true
+		page, err := pager_read(&tree.pager, &tree.meta, id)
+		if err != nil {
+			if errors.Is(err, ErrInvalidFile) {
+				check_report(c, id, "%v", err)
+				break
+			}
+			return err
+		}
+		if page_kind(page) != PAGE_FREE {
+			check_report(c, id, "on the free list but of kind %d", page_kind(page))
+		}
+		from, id = id, page_next(page)
+		pager_unpin(&tree.pager, page)
+	}
+	if n != tree.meta.nfree {
+		check_report(c, 0, "free list holds %d pages, not %d", n, tree.meta.nfree)
+	}
+	return nil
+}
+
+func disk_tree_check[K, V any](tree *disk_tree[K, V]) ([]Violation, error) {
+
+	c := &disk_checker[K, V]{
+		tree: tree,
+		seen: make([]bool, tree.meta.npages),
+		last: make([]pgid, tree.meta.height+1),
+		next: make([]pgid, tree.meta.height+1),
+	}
+	if tree.meta.root != 0 {
+		if err := check_node(c, tree.meta.root, 0, tree.meta.height, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	for level := 1; level <= tree.meta.height; level++ {
+		if c.last[level] != 0 && c.last[level] != check_unknown && c.next[level] != check_unknown && c.next[level] != 0 {
+			check_report(c, c.last[level], "next is page %d, past the end of its level", c.next[level])
+		}
+	}
+	if c.count != tree.meta.count && !c.lost {
+		check_report(c, 0, "the leaves hold %d entries, not %d", c.count, tree.meta.count)
+	}
+	if err := check_free(c); err != nil {
+		return nil, err
+	}
+	return c.found, nil
+}
+
+// Check walks every page of the tree and verifies the invariants of a
+// B+ tree: each node's keys are sorted and lie between the separators
+// that lead to it, each node is neither over- nor underfull, parent
+// pointers match, all leaves are at the same depth, and the next links
+// of every level, the leaf chain included, visit its nodes in order. It
+// also checks the key count and the free list. Check reports every
+// violation it finds, in the order it walks the tree, and returns an
+// error only if reading the file fails; damaged pages are violations.
+// It runs alongside lookups and scans but blocks modifications.
+func (t *DiskTree[K, V]) Check() ([]Violation, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tree == nil {
+		return nil, ErrClosed
+	}
+	return disk_tree_check(t.tree)
+}
@@ -0,0 +1,118 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* the pages on each level of a tree, from the leaves up */
+func check_levels[K, V any](t *testing.T, tree *disk_tree[K, V]) [][]pgid {
+	t.Helper()
+	levels := make([][]pgid, tree.meta.height+1)
+	var walk func(id pgid, level int)
+	walk = func(id pgid, level int) {
+		page, err := disk_fetch(tree, id, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer disk_release(tree, page)
+		levels[level] = append(levels[level], id)
+		if level > 1 {
+			for i := 0; i < page_count(page); i++ {
+				walk(disk_sub(tree, page, i), level-1)
+			}
+		}
+	}
+	if tree.meta.root != 0 {
+		walk(tree.meta.root, tree.meta.height)
+	}
+	return levels
+}
+
+/* overwrite the count of page id in a file image, and reseal the page */
+func check_poke_count(image []byte, id pgid, count int) {
+	page := image[int(id)*PAGE_SIZE : int(id+1)*PAGE_SIZE]
+	binary.LittleEndian.PutUint32(page[4:], uint32(count))
+	binary.LittleEndian.PutUint32(page[page_crc:], page_checksum(page, page_crc))
+}
+
+func TestCheckHealthy(t *testing.T) {
+	for _, geometry := range [][2]int{{4, 3}, {4, 4}, {5, 4}, {7, 9}} {
+		path := filepath.Join(t.TempDir(), "tree.db")
+		tree, err := Open(path, Int64Codec(), Int64Codec(), WithOrder(geometry[0]), WithEntries(geometry[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := int64(0); i < 3000; i++ {
+			tree.Put(i*7919%3000, i)
+		}
+		/* thin it out so most nodes sit at their minimum */
+		for i := int64(0); i < 3000; i++ {
+			if i%5 != 0 {
+				tree.Delete(i)
+			}
+		}
+		violations, err := tree.Check()
+		if err != nil || len(violations) != 0 {
+			t.Fatalf("order %d, entries %d: Check() = %v, %v", geometry[0], geometry[1], violations, err)
+		}
+		tree.Close()
+	}
+}
+
+func TestCheckUnderfull(t *testing.T) {
+	const order, entries = 6, 4
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := Open(path, Int64Codec(), Int64Codec(), WithOrder(order), WithEntries(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 400; i++ {
+		tree.Put(i, i)
+	}
+	levels := check_levels(t, tree.tree)
+	if len(levels) < 4 {
+		t.Fatalf("a tree of height %d has no non-leaf below the root", len(levels)-1)
+	}
+	leaf, node := levels[1][5], levels[2][3]
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* one below the fewest a split or removal leaves */
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_poke_count(image, leaf, (entries+1)/2-1)
+	check_poke_count(image, node, order/2-1)
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(path, Int64Codec(), Int64Codec(), WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	violations, err := tree.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []Violation{
+		{uint64(leaf), fmt.Sprintf("holds %d entries, fewer than %d", (entries+1)/2-1, (entries+1)/2)},
+		{uint64(node), fmt.Sprintf("has %d children, fewer than %d", order/2-1, order/2)},
+	} {
+		found := false
+		for _, v := range violations {
+			found = found || v.PageID == want.PageID && strings.Contains(v.Problem, want.Problem)
+		}
+		if !found {
+			t.Errorf("Check() missed %v, found %v", want, violations)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Command bptree inspects the files of bplustree.DiskTree.
+//
+// Usage:
+//
+//	bptree check [-key type] [-value type] file
+//
+// Check opens the tree read-only and verifies every page against the
+// invariants of a B+ tree, printing each violation it finds. It exits
+// with status 0 if the tree is sound, 1 if it is not and 2 if the file
+// cannot be checked at all. The key and value types must be those the
+// file was written with: int64, uint64, int32, uint32, float64 or
+// string:N for strings of up to N bytes. Both default to int64.
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cagnosolutions/bplustree"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bptree check [-key type] [-value type] file")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "check" {
+		usage()
+	}
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = usage
+	key := fs.String("key", "int64", "key `type`: int64, uint64, int32, uint32, float64 or string:N")
+	value := fs.String("value", "int64", "value `type`, as for -key")
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	n, err := checkKey(fs.Arg(0), *key, *value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bptree: %s: %v\n", fs.Arg(0), err)
+		os.Exit(2)
+	}
+	switch {
+	case n == 1:
+		fmt.Println("1 violation")
+		os.Exit(1)
+	case n > 1:
+		fmt.Printf("%d violations\n", n)
+		os.Exit(1)
+	}
+}
+
+// stringSize returns N for a type named string:N.
+func stringSize(typ string) (int, bool) {
+	s, ok := strings.CutPrefix(typ, "string:")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil && n > 0 && n < 1<<16
+}
+
+func checkKey(path, key, value string) (int, error) {
+	switch key {
+	case "int64":
+		return checkValue(path, bplustree.Int64Codec(), value)
+	case "uint64":
+		return checkValue(path, bplustree.Uint64Codec(), value)
+	case "int32":
+		return checkValue(path, bplustree.Int32Codec(), value)
+	case "uint32":
+		return checkValue(path, bplustree.Uint32Codec(), value)
+	case "float64":
+		return checkValue(path, bplustree.Float64Codec(), value)
+	}
+	if n, ok := stringSize(key); ok {
+		return checkValue(path, bplustree.StringCodec(n), value)
+	}
+	return 0, fmt.Errorf("unknown key type %q", key)
+}
+
+func checkValue[K cmp.Ordered](path string, kc bplustree.Codec[K], value string) (int, error) {
+	switch value {
+	case "int64":
+		return check(path, kc, bplustree.Int64Codec())
+	case "uint64":
+		return check(path, kc, bplustree.Uint64Codec())
+	case "int32":
+		return check(path, kc, bplustree.Int32Codec())
+	case "uint32":
+		return check(path, kc, bplustree.Uint32Codec())
+	case "float64":
+		return check(path, kc, bplustree.Float64Codec())
+	}
+	if n, ok := stringSize(value); ok {
+		return check(path, kc, bplustree.StringCodec(n))
+	}
+	return 0, fmt.Errorf("unknown value type %q", value)
+}
+
+// check prints the violations found in the tree at path and returns how
+// many there are. A file too damaged to open counts as one.
+func check[K cmp.Ordered, V any](path string, kc bplustree.Codec[K], vc bplustree.Codec[V]) (int, error) {
+	t, err := bplustree.Open(path, kc, vc, bplustree.WithReadOnly())
+	var corrupt bplustree.ErrCorruptPage
+	if errors.As(err, &corrupt) {
+		fmt.Println(bplustree.Violation{PageID: corrupt.PageID, Problem: err.Error()})
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer t.Close()
+
+	found, err := t.Check()
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range found {
+		fmt.Println(v)
+	}
+	return len(found), nil
+}
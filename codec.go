@@ -0,0 +1,143 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Codec converts values of type T to and from a fixed number of bytes,
+// the form in which a DiskTree stores its keys and values. Every value
+// encodes to exactly Size bytes, which is what lets a page hold a fixed
+// number of entries.
+type Codec[T any] interface {
+	// Size returns the number of bytes every value encodes to.
+	Size() int
+	// Encode writes v to dst, which is Size bytes long. It returns an
+	// error if v cannot be represented in Size bytes.
+	Encode(dst []byte, v T) error
+	// Decode returns the value written to src by Encode. It must not
+	// keep src, which may be a page the tree reuses or unmaps.
+	Decode(src []byte) T
+}
+
+type int64Codec struct{}
+
+func (int64Codec) Size() int { return 8 }
+
+func (int64Codec) Encode(dst []byte, v int64) error {
+	binary.LittleEndian.PutUint64(dst, uint64(v))
+	return nil
+}
+
+func (int64Codec) Decode(src []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(src))
+}
+
+// Int64Codec encodes int64 values in 8 bytes.
+func Int64Codec() Codec[int64] {
+	return int64Codec{}
+}
+
+type uint64Codec struct{}
+
+func (uint64Codec) Size() int { return 8 }
+
+func (uint64Codec) Encode(dst []byte, v uint64) error {
+	binary.LittleEndian.PutUint64(dst, v)
+	return nil
+}
+
+func (uint64Codec) Decode(src []byte) uint64 {
+	return binary.LittleEndian.Uint64(src)
+}
+
+// Uint64Codec encodes uint64 values in 8 bytes.
+func Uint64Codec() Codec[uint64] {
+	return uint64Codec{}
+}
+
+type int32Codec struct{}
+
+func (int32Codec) Size() int { return 4 }
+
+func (int32Codec) Encode(dst []byte, v int32) error {
+	binary.LittleEndian.PutUint32(dst, uint32(v))
+	return nil
+}
+
+func (int32Codec) Decode(src []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(src))
+}
+
+// Int32Codec encodes int32 values in 4 bytes.
+func Int32Codec() Codec[int32] {
+	return int32Codec{}
+}
+
+type uint32Codec struct{}
+
+func (uint32Codec) Size() int { return 4 }
+
+func (uint32Codec) Encode(dst []byte, v uint32) error {
+	binary.LittleEndian.PutUint32(dst, v)
+	return nil
+}
+
+func (uint32Codec) Decode(src []byte) uint32 {
+	return binary.LittleEndian.Uint32(src)
+}
+
+// Uint32Codec encodes uint32 values in 4 bytes.
+func Uint32Codec() Codec[uint32] {
+	return uint32Codec{}
+}
+
+type float64Codec struct{}
+
+func (float64Codec) Size() int { return 8 }
+
+func (float64Codec) Encode(dst []byte, v float64) error {
+	binary.LittleEndian.PutUint64(dst, math.Float64bits(v))
+	return nil
+}
+
+func (float64Codec) Decode(src []byte) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(src))
+}
+
+// Float64Codec encodes float64 values in 8 bytes.
+func Float64Codec() Codec[float64] {
+	return float64Codec{}
+}
+
+type stringCodec struct {
+	max int
+}
+
+func (c stringCodec) Size() int { return 2 + c.max }
+
+func (c stringCodec) Encode(dst []byte, v string) error {
+	if len(v) > c.max {
+		return fmt.Errorf("bplustree: string of %d bytes does not fit a %d byte codec", len(v), c.max)
+	}
+	binary.LittleEndian.PutUint16(dst, uint16(len(v)))
+	n := copy(dst[2:], v)
+	clear(dst[2+n:])
+	return nil
+}
+
+func (c stringCodec) Decode(src []byte) string {
+	n := min(int(binary.LittleEndian.Uint16(src)), c.max)
+	return string(src[2 : 2+n])
+}
+
+// StringCodec encodes strings of up to max bytes in max+2 bytes, a
+// length followed by the string padded with zeros. Encoding a longer
+// string fails. StringCodec panics unless 0 < max < 65536.
+func StringCodec(max int) Codec[string] {
+	if max <= 0 || max > math.MaxUint16 {
+		panic("bplustree: string codec size out of range")
+	}
+	return stringCodec{max: max}
+}
@@ -0,0 +1,232 @@
+package bplustree
+
+// Cursor walks the entries of a tree in key order in either direction,
+// following the doubly-linked chain of leaves.
+//
+// A cursor is positioned on an entry, before the first entry or after
+// the last one; Key and Value return the entry the cursor was last
+// moved onto and are only meaningful while Valid reports true. Stepping
+// off either end leaves the cursor just past that end, so a following
+// Next or Prev steps back onto the boundary entry.
+//
+// Each cursor method takes the tree's reader lock, so the tree may be
+// modified between calls. When that happens the cursor finds its place
+// again by key: Next moves to the smallest key greater than the current
+// one and Prev to the largest key smaller than it. On a tree created with
+// WithLatchCrabbing every step finds its place by key this way. A cursor
+// must not be used by more than one goroutine at a time.
+type Cursor[K, V any] struct {
+	t     *Tree[K, V]
+	leaf  *bplus_leaf[K, V]
+	index int
+	mods  uint64
+	valid bool
+	key   K
+	value V
+}
+
+// Cursor returns an unpositioned cursor over t.
+func (t *Tree[K, V]) Cursor() *Cursor[K, V] {
+	return &Cursor[K, V]{t: t}
+}
+
+// First moves the cursor to the smallest key and reports whether the
+// tree has one.
+func (c *Cursor[K, V]) First() bool {
+	c.t.mu.RLock()
+	defer c.t.mu.RUnlock()
+	if c.t.crabbing {
+		var zero K
+		return c.find(zero, RANGE_NO_MIN, false)
+	}
+	c.first()
+	return c.settle()
+}
+
+// Last moves the cursor to the largest key and reports whether the tree
+// has one.
+func (c *Cursor[K, V]) Last() bool {
+	c.t.mu.RLock()
+	defer c.t.mu.RUnlock()
+	if c.t.crabbing {
+		var zero K
+		return c.find(zero, RANGE_NO_MAX, true)
+	}
+	c.last()
+	return c.settle()
+}
+
+// Seek moves the cursor to the smallest key greater than or equal to
+// key and reports whether there is one. If every key is smaller, the
+// cursor is left after the last entry, so Prev moves to the largest key
+// below key.
+func (c *Cursor[K, V]) Seek(key K) bool {
+	c.t.mu.RLock()
+	defer c.t.mu.RUnlock()
+	if c.t.crabbing {
+		return c.find(key, 0, false)
+	}
+	c.seek(key)
+	return c.settle()
+}
+
+// Next advances the cursor to the following key and reports whether
+// there is one.
+func (c *Cursor[K, V]) Next() bool {
+	c.t.mu.RLock()
+	defer c.t.mu.RUnlock()
+	if c.t.crabbing {
+		switch {
+		case c.valid:
+			return c.find(c.key, RANGE_EXCLUDE_MIN, false)
+		case c.index < 0:
+			var zero K
+			return c.find(zero, RANGE_NO_MIN, false)
+		}
+		return false
+	}
+	if c.mods != c.t.mods.Load() {
+		switch {
+		case c.valid:
+			c.seek(c.key)
+			if c.leaf != nil && c.index < c.leaf.entries && c.t.tree.compare(c.leaf.key[c.index], c.key) != 0 {
+				/* the current key is gone, its successor is under the cursor */
+				return c.settle()
+			}
+		case c.leaf != nil && c.index < 0:
+			c.first()
+			return c.settle()
+		default:
+			c.mods = c.t.mods.Load()
+			return false
+		}
+	}
+	if c.leaf == nil || c.index >= c.leaf.entries {
+		return c.settle()
+	}
+	c.index++
+	if c.index >= c.leaf.entries && c.leaf.next != nil {
+		c.leaf = c.leaf.next
+		c.index = 0
+	}
+	return c.settle()
+}
+
+// Prev moves the cursor to the preceding key and reports whether there
+// is one.
+func (c *Cursor[K, V]) Prev() bool {
+	c.t.mu.RLock()
+	defer c.t.mu.RUnlock()
+	if c.t.crabbing {
+		switch {
+		case c.valid:
+			return c.find(c.key, RANGE_EXCLUDE_MAX, true)
+		case c.index > 0:
+			var zero K
+			return c.find(zero, RANGE_NO_MAX, true)
+		}
+		return false
+	}
+	if c.mods != c.t.mods.Load() {
+		switch {
+		case c.valid:
+			c.seek(c.key)
+		case c.leaf != nil && c.index >= 0:
+			c.last()
+			return c.settle()
+		default:
+			c.mods = c.t.mods.Load()
+			return false
+		}
+	}
+	if c.leaf == nil || c.index < 0 {
+		return c.settle()
+	}
+	c.index--
+	if c.index < 0 && c.leaf.prev != nil {
+		c.leaf = c.leaf.prev
+		c.index = c.leaf.entries - 1
+	}
+	return c.settle()
+}
+
+// Valid reports whether the cursor is positioned on an entry.
+func (c *Cursor[K, V]) Valid() bool {
+	return c.valid
+}
+
+// Key returns the key at the cursor position.
+func (c *Cursor[K, V]) Key() K {
+	return c.key
+}
+
+// Value returns the value at the cursor position.
+func (c *Cursor[K, V]) Value() V {
+	return c.value
+}
+
+func (c *Cursor[K, V]) first() {
+	c.leaf = bplus_tree_first_leaf(c.t.tree)
+	c.index = 0
+}
+
+func (c *Cursor[K, V]) last() {
+	c.leaf = bplus_tree_last_leaf(c.t.tree)
+	c.index = 0
+	if c.leaf != nil {
+		c.index = c.leaf.entries - 1
+	}
+}
+
+func (c *Cursor[K, V]) seek(key K) {
+	c.leaf = bplus_tree_locate(c.t.tree, key)
+	c.index = 0
+	if c.leaf == nil {
+		return
+	}
+	i := key_binary_search(c.leaf.key, c.leaf.entries, key, c.t.tree.compare)
+	if i < 0 {
+		i = -i - 1
+	}
+	c.index = i
+	if c.index >= c.leaf.entries && c.leaf.next != nil {
+		c.leaf = c.leaf.next
+		c.index = 0
+	}
+}
+
+// settle records the entry under the cursor, if any, along with the
+// tree version it was read at. It must be called with the reader lock
+// held.
+func (c *Cursor[K, V]) settle() bool {
+	c.mods = c.t.mods.Load()
+	c.valid = c.leaf != nil && c.index >= 0 && c.index < c.leaf.entries
+	if c.valid {
+		c.key = c.leaf.key[c.index]
+		c.value = c.leaf.data[c.index]
+	}
+	return c.valid
+}
+
+// find moves the cursor to the first entry of a scan starting at key in
+// the given direction, leaving it past the end of the scan if there is
+// none. It keeps no leaf and is how a cursor steps in crabbing mode.
+func (c *Cursor[K, V]) find(key K, flags int, reverse bool) bool {
+	c.leaf = nil
+	c.valid = false
+	collect := func(k K, v V) bool {
+		c.key, c.value, c.valid = k, v, true
+		return false
+	}
+	if reverse {
+		bplus_tree_get_range_reverse(c.t.tree, key, key, flags|RANGE_NO_MIN, collect)
+		c.index = -1
+	} else {
+		bplus_tree_get_range(c.t.tree, key, key, flags|RANGE_NO_MAX, collect)
+		c.index = 1
+	}
+	if c.valid {
+		c.index = 0
+	}
+	return c.valid
+}
@@ -0,0 +1,182 @@
+package bplustree
+
+import (
+	"slices"
+	"testing"
+)
+
+func cursor_tree(t *testing.T) *Tree[int, int] {
+	t.Helper()
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for k := 10; k <= 100; k += 10 {
+		tree.Put(k, k*2)
+	}
+	return tree
+}
+
+func TestCursorSeek(t *testing.T) {
+	tree := cursor_tree(t)
+	tests := []struct {
+		name  string
+		seek  int
+		valid bool
+		key   int
+		next  []int
+		prev  []int
+	}{
+		{name: "exact", seek: 40, valid: true, key: 40, next: []int{50, 60, 70}, prev: []int{30, 20, 10}},
+		{name: "between keys", seek: 45, valid: true, key: 50, next: []int{60, 70, 80}, prev: []int{40, 30, 20}},
+		{name: "before first", seek: 0, valid: true, key: 10, next: []int{20, 30, 40}},
+		{name: "last", seek: 100, valid: true, key: 100, prev: []int{90, 80, 70}},
+		{name: "after last", seek: 101, valid: false, prev: []int{100, 90, 80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tree.Cursor()
+			if got := c.Seek(tt.seek); got != tt.valid {
+				t.Fatalf("Seek(%d) = %t, want %t", tt.seek, got, tt.valid)
+			}
+			if tt.valid && (c.Key() != tt.key || c.Value() != tt.key*2) {
+				t.Fatalf("Seek(%d) at %d => %d, want %d", tt.seek, c.Key(), c.Value(), tt.key)
+			}
+			for _, want := range tt.next {
+				if !c.Next() || c.Key() != want {
+					t.Fatalf("Next after Seek(%d) did not reach %d", tt.seek, want)
+				}
+			}
+			c.Seek(tt.seek)
+			for _, want := range tt.prev {
+				if !c.Prev() || c.Key() != want {
+					t.Fatalf("Prev after Seek(%d) did not reach %d", tt.seek, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCursorEnds(t *testing.T) {
+	tree := cursor_tree(t)
+	c := tree.Cursor()
+	if c.Valid() {
+		t.Fatal("unpositioned cursor is valid")
+	}
+	if !c.Last() || c.Key() != 100 {
+		t.Fatal("Last did not reach 100")
+	}
+	if c.Next() || c.Valid() {
+		t.Fatal("Next past the last key reported an entry")
+	}
+	if !c.Prev() || c.Key() != 100 {
+		t.Fatal("Prev from after the end did not step back onto 100")
+	}
+	if !c.First() || c.Key() != 10 {
+		t.Fatal("First did not reach 10")
+	}
+	if c.Prev() || c.Valid() {
+		t.Fatal("Prev before the first key reported an entry")
+	}
+	if !c.Next() || c.Key() != 10 {
+		t.Fatal("Next from before the start did not step back onto 10")
+	}
+
+	empty := New[int, int]().Cursor()
+	if empty.First() || empty.Last() || empty.Seek(1) {
+		t.Fatal("cursor over an empty tree reported an entry")
+	}
+}
+
+// TestCursorChain walks the whole tree in both directions while splits
+// and merges rewrite the leaf chain.
+func TestCursorChain(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	var want []int
+	for k := 0; k < 300; k++ {
+		tree.Put(k*37%300, k)
+	}
+	for k := 0; k < 300; k++ {
+		if k%3 == 0 {
+			tree.Delete(k)
+		} else {
+			want = append(want, k)
+		}
+	}
+	var fwd, back []int
+	c := tree.Cursor()
+	for ok := c.First(); ok; ok = c.Next() {
+		fwd = append(fwd, c.Key())
+	}
+	for ok := c.Last(); ok; ok = c.Prev() {
+		back = append(back, c.Key())
+	}
+	slices.Reverse(back)
+	if !slices.Equal(fwd, want) {
+		t.Fatalf("forward walk = %v, want %v", fwd, want)
+	}
+	if !slices.Equal(back, want) {
+		t.Fatalf("backward walk = %v, want %v", back, want)
+	}
+}
+
+func TestCursorReseek(t *testing.T) {
+	tests := []struct {
+		name   string
+		at     int // key the cursor sits on, 0 to step off the front
+		change func(tree *Tree[int, int])
+		next   bool // step with Next rather than Prev
+		want   int  // key reached, 0 if none
+	}{
+		{name: "Next after deleting the current key", at: 40, change: func(tr *Tree[int, int]) { tr.Delete(40) }, next: true, want: 50},
+		{name: "Prev after deleting the current key", at: 40, change: func(tr *Tree[int, int]) { tr.Delete(40) }, want: 30},
+		{name: "Next after deleting the successor", at: 40, change: func(tr *Tree[int, int]) { tr.Delete(50) }, next: true, want: 60},
+		{name: "Prev after deleting the predecessor", at: 40, change: func(tr *Tree[int, int]) { tr.Delete(30) }, want: 20},
+		{name: "Next after inserting a successor", at: 40, change: func(tr *Tree[int, int]) { tr.Put(45, 90) }, next: true, want: 45},
+		{name: "Prev after inserting a predecessor", at: 40, change: func(tr *Tree[int, int]) { tr.Put(35, 70) }, want: 35},
+		{
+			name: "Next after the tree splits",
+			at:   40,
+			change: func(tr *Tree[int, int]) {
+				for k := 41; k < 50; k++ {
+					tr.Put(k, k*2)
+				}
+			},
+			next: true, want: 41,
+		},
+		{
+			name: "Next after every key is deleted",
+			at:   40,
+			change: func(tr *Tree[int, int]) {
+				for k := 10; k <= 100; k += 10 {
+					tr.Delete(k)
+				}
+			},
+			next: true,
+		},
+		{name: "Next from before the start", change: func(tr *Tree[int, int]) { tr.Put(5, 10) }, next: true, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := cursor_tree(t)
+			c := tree.Cursor()
+			if tt.at != 0 {
+				c.Seek(tt.at)
+			} else {
+				c.First()
+				c.Prev()
+			}
+			tt.change(tree)
+			step := c.Prev
+			if tt.next {
+				step = c.Next
+			}
+			ok := step()
+			if ok != (tt.want != 0) || (ok && c.Key() != tt.want) {
+				t.Fatalf("step = %t at %d, want %d", ok, c.Key(), tt.want)
+			}
+			if ok {
+				if v, _ := tree.Get(tt.want); c.Value() != v {
+					t.Fatalf("Value = %d, want %d", c.Value(), v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,1841 @@
+package bplustree
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * Disk-backed tree
+ *
+ * The B+ tree of bplustree.go with its nodes stored in the pages of a
+ * file, see page.go, and linked by page id instead of by pointer. The
+ * insertion and removal code follows the in-memory version step by step,
+ * moving encoded keys and values between page slots.
+ *
+ * Pages are read through the buffer pool of pool.go. A lookup pins one
+ * page at a time, letting go of each node once it knows the next. A
+ * modification keeps every page it touches pinned, along with a copy of
+ * its contents, until it is done; if it fails part way the pages and the
+ * meta fields are put back as they were. Otherwise the pages it changed
+ * are logged, see wal.go, and reach the file when they are evicted or
+ * the tree is flushed.
+ */
+
+type disk_tree[K, V any] struct {
+	pager   pager
+	wal     write_ahead_log
+	meta    disk_meta
+	lsn     uint64 /* in the meta on disk */
+	slot    int    /* of the newer meta copy */
+	ro      bool   /* opened read-only, see disk_tree_open_ro */
+	order   int
+	entries int
+	ksize   int
+	vsize   int
+	kc      Codec[K]
+	vc      Codec[V]
+	compare func(a, b K) int
+}
+
+func disk_key_at[K, V any](tree *disk_tree[K, V], p *disk_page, i int) []byte {
+	off := PAGE_HEADER + i*tree.ksize
+	return p.buf[off : off+tree.ksize]
+}
+
+func disk_data_at[K, V any](tree *disk_tree[K, V], p *disk_page, i int) []byte {
+	off := PAGE_HEADER + tree.entries*tree.ksize + i*tree.vsize
+	return p.buf[off : off+tree.vsize]
+}
+
+func disk_key[K, V any](tree *disk_tree[K, V], p *disk_page, i int) K {
+	return tree.kc.Decode(disk_key_at(tree, p, i))
+}
+
+func disk_data[K, V any](tree *disk_tree[K, V], p *disk_page, i int) V {
+	return tree.vc.Decode(disk_data_at(tree, p, i))
+}
+
+func disk_set_key[K, V any](tree *disk_tree[K, V], p *disk_page, i int, key []byte) {
+	copy(disk_key_at(tree, p, i), key)
+	p.dirty = true
+}
+
+func disk_set_data[K, V any](tree *disk_tree[K, V], p *disk_page, i int, data []byte) {
+	copy(disk_data_at(tree, p, i), data)
+	p.dirty = true
+}
+
+func disk_sub[K, V any](tree *disk_tree[K, V], p *disk_page, i int) pgid {
+	off := PAGE_HEADER + (tree.order-1)*tree.ksize + i*8
+	return pgid(binary.LittleEndian.Uint64(p.buf[off:]))
+}
+
+func disk_set_sub[K, V any](tree *disk_tree[K, V], p *disk_page, i int, id pgid) {
+	off := PAGE_HEADER + (tree.order-1)*tree.ksize + i*8
+	binary.LittleEndian.PutUint64(p.buf[off:], uint64(id))
+	p.dirty = true
+}
+
+/* copy entry i of leaf src to entry j of leaf dst */
+func disk_leaf_move[K, V any](tree *disk_tree[K, V], dst *disk_page, j int, src *disk_page, i int) {
+	disk_set_key(tree, dst, j, disk_key_at(tree, src, i))
+	disk_set_data(tree, dst, j, disk_data_at(tree, src, i))
+}
+
+func disk_search[K, V any](tree *disk_tree[K, V], p *disk_page, length int, target K) int {
+	low, high := -1, length
+	for low+1 < high {
+		mid := low + (high-low)/2
+		if tree.compare(target, disk_key(tree, p, mid)) > 0 {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	if high >= length || tree.compare(disk_key(tree, p, high), target) != 0 {
+		return -high - 1
+	}
+	return high
+}
+
+func disk_non_leaf_index[K, V any](tree *disk_tree[K, V], node *disk_page, key K) int {
+
+	var i int = disk_search(tree, node, page_count(node)-1, key)
+	if i >= 0 {
+		return i + 1
+	}
+	return -i - 1
+}
+
+/* position of child among the sub-nodes of parent */
+func disk_sub_index[K, V any](tree *disk_tree[K, V], parent *disk_page, child pgid) (int, error) {
+	for i := 0; i < page_count(parent); i++ {
+		if disk_sub(tree, parent, i) == child {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: page %d is not a child of page %d", ErrInvalidFile, child, parent.id)
+}
+
+/* read page id, for modification if write is set, and check its header */
+func disk_fetch[K, V any](tree *disk_tree[K, V], id pgid, write bool) (*disk_page, error) {
+
+	var page *disk_page
+	var err error
+	if write {
+		page, err = pager_get(&tree.pager, &tree.meta, id)
+	} else {
+		page, err = pager_read(&tree.pager, &tree.meta, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch page_kind(page) {
+	case BPLUS_TREE_LEAF:
+		if page_count(page) <= tree.entries {
+			return page, nil
+		}
+	case BPLUS_TREE_NON_LEAF:
+		if page_count(page) > 0 && page_count(page) <= tree.order {
+			return page, nil
+		}
+	}
+	if !write {
+		disk_release(tree, page)
+	}
+	return nil, ErrCorruptPage{PageID: uint64(id)}
+}
+
+/* let go of a page read by disk_fetch without write */
+func disk_release[K, V any](tree *disk_tree[K, V], page *disk_page) {
+	pager_unpin(&tree.pager, page)
+}
+
+/* set the parent of page id, which is being modified */
+func disk_set_parent[K, V any](tree *disk_tree[K, V], id pgid, parent pgid) error {
+	page, err := disk_fetch(tree, id, true)
+	if err != nil {
+		return err
+	}
+	page_set_parent(page, parent)
+	return nil
+}
+
+func disk_non_leaf_new[K, V any](tree *disk_tree[K, V]) (*disk_page, error) {
+	page, err := pager_alloc(&tree.pager, &tree.meta)
+	if err != nil {
+		return nil, err
+	}
+	page_set_kind(page, BPLUS_TREE_NON_LEAF)
+	return page, nil
+}
+
+func disk_leaf_new[K, V any](tree *disk_tree[K, V]) (*disk_page, error) {
+	page, err := pager_alloc(&tree.pager, &tree.meta)
+	if err != nil {
+		return nil, err
+	}
+	page_set_kind(page, BPLUS_TREE_LEAF)
+	return page, nil
+}
+
+func disk_page_delete[K, V any](tree *disk_tree[K, V], page *disk_page) {
+	pager_free(&tree.pager, &tree.meta, page)
+}
+
+/*
+ * Descend to the leaf for key, for modification if write is set. The
+ * leaf is returned pinned and, without write, must be released.
+ */
+func disk_tree_locate[K, V any](tree *disk_tree[K, V], key K, write bool) (*disk_page, error) {
+
+	var id pgid = tree.meta.root
+
+	for level := tree.meta.height; id != 0; level-- {
+		page, err := disk_fetch(tree, id, write)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case level == 1 && page_kind(page) == BPLUS_TREE_LEAF:
+			return page, nil
+		case level > 1 && page_kind(page) == BPLUS_TREE_NON_LEAF:
+			id = disk_sub(tree, page, disk_non_leaf_index(tree, page, key))
+		default:
+			err = fmt.Errorf("%w: page %d is at the wrong depth", ErrInvalidFile, id)
+		}
+		if !write {
+			disk_release(tree, page)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+/* the leftmost or rightmost leaf, pinned */
+func disk_tree_edge_leaf[K, V any](tree *disk_tree[K, V], last bool) (*disk_page, error) {
+
+	var id pgid = tree.meta.root
+
+	for level := tree.meta.height; id != 0; level-- {
+		page, err := disk_fetch(tree, id, false)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case level == 1 && page_kind(page) == BPLUS_TREE_LEAF:
+			return page, nil
+		case level > 1 && page_kind(page) == BPLUS_TREE_NON_LEAF:
+			if last {
+				id = disk_sub(tree, page, page_count(page)-1)
+			} else {
+				id = disk_sub(tree, page, 0)
+			}
+		default:
+			err = fmt.Errorf("%w: page %d is at the wrong depth", ErrInvalidFile, id)
+		}
+		disk_release(tree, page)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func disk_tree_search[K, V any](tree *disk_tree[K, V], key K) (V, bool, error) {
+
+	var zero V
+	ln, err := disk_tree_locate(tree, key, false)
+	if err != nil || ln == nil {
+		return zero, false, err
+	}
+	defer disk_release(tree, ln)
+	i := disk_search(tree, ln, page_count(ln), key)
+	if i < 0 {
+		return zero, false, nil
+	}
+	return disk_data(tree, ln, i), true, nil
+}
+
+func disk_non_leaf_insert[K, V any](tree *disk_tree[K, V], node *disk_page, sub_node *disk_page, key []byte) error {
+
+	var i, j int
+	var split_key []byte
+	var split int = 0
+	var sibling *disk_page
+	var err error
+
+	var insert int = disk_search(tree, node, page_count(node)-1, tree.kc.Decode(key))
+	if insert >= 0 {
+		return fmt.Errorf("%w: page %d already holds the separator", ErrInvalidFile, node.id)
+	}
+	insert = -insert - 1
+
+	/* node full */
+	if page_count(node) == tree.order {
+		/* split = [m/2] */
+		split = (tree.order + 1) / 2
+		/* splited sibling node */
+		if sibling, err = disk_non_leaf_new(tree); err != nil {
+			return err
+		}
+		page_set_next(sibling, page_next(node))
+		page_set_next(node, sibling.id)
+		/* non-leaf node's children always equals to split + 1 after insertion */
+		page_set_count(node, split+1)
+		/* sibling node replication due to location of insertion */
+		if insert < split {
+			split_key = bytes.Clone(disk_key_at(tree, node, split-1))
+			/* sibling node's first sub-node */
+			disk_set_sub(tree, sibling, 0, disk_sub(tree, node, split))
+			if err = disk_set_parent(tree, disk_sub(tree, node, split), sibling.id); err != nil {
+				return err
+			}
+			/* insertion point is before split point, replicate from key[split] */
+			for i, j = split, 0; i < tree.order-1; i, j = i+1, j+1 {
+				disk_set_key(tree, sibling, j, disk_key_at(tree, node, i))
+				disk_set_sub(tree, sibling, j+1, disk_sub(tree, node, i+1))
+				if err = disk_set_parent(tree, disk_sub(tree, node, i+1), sibling.id); err != nil {
+					return err
+				}
+			}
+			page_set_count(sibling, j+1)
+			/* insert new key and sub-node */
+			for i = page_count(node) - 2; i > insert; i-- {
+				disk_set_key(tree, node, i, disk_key_at(tree, node, i-1))
+				disk_set_sub(tree, node, i+1, disk_sub(tree, node, i))
+			}
+			disk_set_key(tree, node, i, key)
+			disk_set_sub(tree, node, i+1, sub_node.id)
+			page_set_parent(sub_node, node.id)
+		} else if insert == split {
+			split_key = key
+			/* sibling node's first sub-node */
+			disk_set_sub(tree, sibling, 0, sub_node.id)
+			page_set_parent(sub_node, sibling.id)
+			/* insertion point is split point, replicate from key[split] */
+			for i, j = split, 0; i < tree.order-1; i, j = i+1, j+1 {
+				disk_set_key(tree, sibling, j, disk_key_at(tree, node, i))
+				disk_set_sub(tree, sibling, j+1, disk_sub(tree, node, i+1))
+				if err = disk_set_parent(tree, disk_sub(tree, node, i+1), sibling.id); err != nil {
+					return err
+				}
+			}
+			page_set_count(sibling, j+1)
+		} else {
+			split_key = bytes.Clone(disk_key_at(tree, node, split))
+			/* sibling node's first sub-node */
+			disk_set_sub(tree, sibling, 0, disk_sub(tree, node, split+1))
+			if err = disk_set_parent(tree, disk_sub(tree, node, split+1), sibling.id); err != nil {
+				return err
+			}
+			/* insertion point is after split point, replicate from key[split + 1] */
+			for i, j = split+1, 0; i < tree.order-1; j++ {
+				if j != insert-split-1 {
+					disk_set_key(tree, sibling, j, disk_key_at(tree, node, i))
+					disk_set_sub(tree, sibling, j+1, disk_sub(tree, node, i+1))
+					if err = disk_set_parent(tree, disk_sub(tree, node, i+1), sibling.id); err != nil {
+						return err
+					}
+					i++
+				}
+			}
+			/* reserve a hole for insertion */
+			if j > insert-split-1 {
+				page_set_count(sibling, j+1)
+			} else {
+				page_set_count(sibling, j+2)
+			}
+			/* insert new key and sub-node*/
+			j = insert - split - 1
+			disk_set_key(tree, sibling, j, key)
+			disk_set_sub(tree, sibling, j+1, sub_node.id)
+			page_set_parent(sub_node, sibling.id)
+		}
+	} else {
+		/* simple insertion */
+		for i = page_count(node) - 1; i > insert; i-- {
+			disk_set_key(tree, node, i, disk_key_at(tree, node, i-1))
+			disk_set_sub(tree, node, i+1, disk_sub(tree, node, i))
+		}
+		disk_set_key(tree, node, i, key)
+		disk_set_sub(tree, node, i+1, sub_node.id)
+		page_set_count(node, page_count(node)+1)
+	}
+	if split > 0 {
+		var parent_id pgid = page_parent(node)
+		if parent_id == 0 {
+			/* new parent */
+			parent, err := disk_non_leaf_new(tree)
+			if err != nil {
+				return err
+			}
+			disk_set_key(tree, parent, 0, split_key)
+			disk_set_sub(tree, parent, 0, node.id)
+			disk_set_sub(tree, parent, 1, sibling.id)
+			page_set_count(parent, 2)
+			/* update root */
+			tree.meta.root = parent.id
+			tree.meta.height++
+			page_set_parent(node, parent.id)
+			page_set_parent(sibling, parent.id)
+		} else {
+			/* Trace upwards */
+			parent, err := disk_fetch(tree, parent_id, true)
+			if err != nil {
+				return err
+			}
+			page_set_parent(sibling, parent_id)
+			return disk_non_leaf_insert(tree, parent, sibling, split_key)
+		}
+	}
+	return nil
+}
+
+func disk_leaf_insert[K, V any](tree *disk_tree[K, V], leaf *disk_page, key K, kb []byte, vb []byte) error {
+
+	var i, j, split int
+	var sibling *disk_page
+	var err error
+
+	var insert int = disk_search(tree, leaf, page_count(leaf), key)
+	if insert >= 0 {
+		/* Already exists */
+		return ErrKeyExists
+	}
+	insert = -insert - 1
+
+	/* node full */
+	if page_count(leaf) == tree.entries {
+		/* split = [m/2] */
+		split = (tree.entries + 1) / 2
+		/* splited sibling node */
+		if sibling, err = disk_leaf_new(tree); err != nil {
+			return err
+		}
+		page_set_next(sibling, page_next(leaf))
+		page_set_prev(sibling, leaf.id)
+		if page_next(leaf) != 0 {
+			next, err := disk_fetch(tree, page_next(leaf), true)
+			if err != nil {
+				return err
+			}
+			page_set_prev(next, sibling.id)
+		}
+		page_set_next(leaf, sibling.id)
+		/* leaf node's entries always equals to split after insertion */
+		page_set_count(leaf, split)
+		/* sibling leaf replication due to location of insertion */
+		if insert < split {
+			/* insertion point is before split point, replicate from key[split - 1] */
+			for i, j = split-1, 0; i < tree.entries; i, j = i+1, j+1 {
+				disk_leaf_move(tree, sibling, j, leaf, i)
+			}
+			page_set_count(sibling, j)
+			/* insert new key and sub-node */
+			for i = split - 1; i > insert; i-- {
+				disk_leaf_move(tree, leaf, i, leaf, i-1)
+			}
+			disk_set_key(tree, leaf, i, kb)
+			disk_set_data(tree, leaf, i, vb)
+		} else {
+			/* insertion point is or after split point, replicate from key[split] */
+			for i, j = split, 0; i < tree.entries; j++ {
+				if j != insert-split {
+					disk_leaf_move(tree, sibling, j, leaf, i)
+					i++
+				}
+			}
+			/* reserve a hole for insertion */
+			if j > insert-split {
+				page_set_count(sibling, j)
+			} else {
+				page_set_count(sibling, j+1)
+			}
+			/* insert new key */
+			j = insert - split
+			disk_set_key(tree, sibling, j, kb)
+			disk_set_data(tree, sibling, j, vb)
+		}
+	} else {
+		/* simple insertion */
+		for i = page_count(leaf); i > insert; i-- {
+			disk_leaf_move(tree, leaf, i, leaf, i-1)
+		}
+		disk_set_key(tree, leaf, i, kb)
+		disk_set_data(tree, leaf, i, vb)
+		page_set_count(leaf, page_count(leaf)+1)
+	}
+
+	if split > 0 {
+		var parent_id pgid = page_parent(leaf)
+		if parent_id == 0 {
+			/* new parent */
+			parent, err := disk_non_leaf_new(tree)
+			if err != nil {
+				return err
+			}
+			disk_set_key(tree, parent, 0, disk_key_at(tree, sibling, 0))
+			disk_set_sub(tree, parent, 0, leaf.id)
+			disk_set_sub(tree, parent, 1, sibling.id)
+			page_set_count(parent, 2)
+			/* update root */
+			tree.meta.root = parent.id
+			tree.meta.height++
+			page_set_parent(leaf, parent.id)
+			page_set_parent(sibling, parent.id)
+		} else {
+			/* trace upwards */
+			parent, err := disk_fetch(tree, parent_id, true)
+			if err != nil {
+				return err
+			}
+			page_set_parent(sibling, parent_id)
+			return disk_non_leaf_insert(tree, parent, sibling, bytes.Clone(disk_key_at(tree, sibling, 0)))
+		}
+	}
+	return nil
+}
+
+func disk_leaf_insert_root[K, V any](tree *disk_tree[K, V], kb []byte, vb []byte) error {
+	/* new root */
+	root, err := disk_leaf_new(tree)
+	if err != nil {
+		return err
+	}
+	disk_set_key(tree, root, 0, kb)
+	disk_set_data(tree, root, 0, vb)
+	page_set_count(root, 1)
+
+	tree.meta.root = root.id
+	tree.meta.height = 1
+	return nil
+}
+
+/* pick the sibling of node under parent to borrow from or merge with */
+func disk_sibling[K, V any](tree *disk_tree[K, V], parent *disk_page, node *disk_page) (*disk_page, int, int, error) {
+
+	var sibling *disk_page
+	var borrow int
+
+	i, err := disk_sub_index(tree, parent, node.id)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if i == 0 {
+		/* the first node, no left sibling, choose right one */
+		sibling, err = disk_fetch(tree, disk_sub(tree, parent, i+1), true)
+		borrow = BORROW_FROM_RIGHT
+	} else if i == page_count(parent)-1 {
+		/* the last node, no right sibling, choose left one */
+		sibling, err = disk_fetch(tree, disk_sub(tree, parent, i-1), true)
+		borrow = BORROW_FROM_LEFT
+	} else {
+		var l_sib, r_sib *disk_page
+		if l_sib, err = disk_fetch(tree, disk_sub(tree, parent, i-1), true); err != nil {
+			return nil, 0, 0, err
+		}
+		if r_sib, err = disk_fetch(tree, disk_sub(tree, parent, i+1), true); err != nil {
+			return nil, 0, 0, err
+		}
+		/* if both left and right sibling found, choose the one with more entries */
+		if page_count(l_sib) >= page_count(r_sib) {
+			sibling = l_sib
+			borrow = BORROW_FROM_LEFT
+		} else {
+			sibling = r_sib
+			borrow = BORROW_FROM_RIGHT
+		}
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if page_kind(sibling) != page_kind(node) {
+		return nil, 0, 0, fmt.Errorf("%w: page %d is at the wrong depth", ErrInvalidFile, sibling.id)
+	}
+	return sibling, borrow, i, nil
+}
+
+func disk_non_leaf_remove[K, V any](tree *disk_tree[K, V], node *disk_page, remove int) error {
+
+	var i, j, k int
+	var sibling *disk_page
+	var borrow int
+	var err error
+
+	if page_count(node) <= (tree.order+1)/2 {
+		var parent_id pgid = page_parent(node)
+		if parent_id != 0 {
+			parent, err := disk_fetch(tree, parent_id, true)
+			if err != nil {
+				return err
+			}
+			/* find which sibling node with same parent to be borrowed from */
+			if sibling, borrow, i, err = disk_sibling(tree, parent, node); err != nil {
+				return err
+			}
+
+			/* locate parent node key to update later */
+			i = i - 1
+
+			if borrow == BORROW_FROM_LEFT {
+				if page_count(sibling) > (tree.order+1)/2 {
+					/* node's elements right shift */
+					for j = remove; j > 0; j-- {
+						disk_set_key(tree, node, j, disk_key_at(tree, node, j-1))
+					}
+					for j = remove + 1; j > 0; j-- {
+						disk_set_sub(tree, node, j, disk_sub(tree, node, j-1))
+					}
+					/* parent key right rotation */
+					disk_set_key(tree, node, 0, disk_key_at(tree, parent, i))
+					disk_set_key(tree, parent, i, disk_key_at(tree, sibling, page_count(sibling)-2))
+					/* borrow the last sub-node from left sibling */
+					disk_set_sub(tree, node, 0, disk_sub(tree, sibling, page_count(sibling)-1))
+					if err = disk_set_parent(tree, disk_sub(tree, node, 0), node.id); err != nil {
+						return err
+					}
+					page_set_count(sibling, page_count(sibling)-1)
+				} else {
+					/* move parent key down */
+					disk_set_key(tree, sibling, page_count(sibling)-1, disk_key_at(tree, parent, i))
+					/* merge with left sibling */
+					for j, k = page_count(sibling), 0; k < page_count(node)-1; k++ {
+						if k != remove {
+							disk_set_key(tree, sibling, j, disk_key_at(tree, node, k))
+							j++
+						}
+					}
+					for j, k = page_count(sibling), 0; k < page_count(node); k++ {
+						if k != remove+1 {
+							disk_set_sub(tree, sibling, j, disk_sub(tree, node, k))
+							if err = disk_set_parent(tree, disk_sub(tree, node, k), sibling.id); err != nil {
+								return err
+							}
+							j++
+						}
+					}
+					page_set_count(sibling, j)
+					/* delete merged node */
+					page_set_next(sibling, page_next(node))
+					disk_page_delete(tree, node)
+					/* trace upwards */
+					return disk_non_leaf_remove(tree, parent, i)
+				}
+			} else {
+				/* remove key first in case of overflow during merging with sibling node */
+				for remove < page_count(node)-2 {
+					disk_set_key(tree, node, remove, disk_key_at(tree, node, remove+1))
+					disk_set_sub(tree, node, remove+1, disk_sub(tree, node, remove+2))
+					remove++
+				}
+				page_set_count(node, page_count(node)-1)
+				if page_count(sibling) > (tree.order+1)/2 {
+					/* parent key left rotation */
+					disk_set_key(tree, node, page_count(node)-1, disk_key_at(tree, parent, i+1))
+					disk_set_key(tree, parent, i+1, disk_key_at(tree, sibling, 0))
+					/* borrow the frist sub-node from right sibling */
+					disk_set_sub(tree, node, page_count(node), disk_sub(tree, sibling, 0))
+					if err = disk_set_parent(tree, disk_sub(tree, sibling, 0), node.id); err != nil {
+						return err
+					}
+					page_set_count(node, page_count(node)+1)
+					/* left shift in right sibling */
+					for j = 0; j < page_count(sibling)-2; j++ {
+						disk_set_key(tree, sibling, j, disk_key_at(tree, sibling, j+1))
+					}
+					for j = 0; j < page_count(sibling)-1; j++ {
+						disk_set_sub(tree, sibling, j, disk_sub(tree, sibling, j+1))
+					}
+					page_set_count(sibling, page_count(sibling)-1)
+				} else {
+					/* move parent key down */
+					disk_set_key(tree, node, page_count(node)-1, disk_key_at(tree, parent, i+1))
+					page_set_count(node, page_count(node)+1)
+					/* merge with right sibling */
+					for j, k = page_count(node)-1, 0; k < page_count(sibling)-1; j, k = j+1, k+1 {
+						disk_set_key(tree, node, j, disk_key_at(tree, sibling, k))
+					}
+					for j, k = page_count(node)-1, 0; k < page_count(sibling); j, k = j+1, k+1 {
+						disk_set_sub(tree, node, j, disk_sub(tree, sibling, k))
+						if err = disk_set_parent(tree, disk_sub(tree, sibling, k), node.id); err != nil {
+							return err
+						}
+					}
+					page_set_count(node, j)
+					/* delete merged sibling */
+					page_set_next(node, page_next(sibling))
+					disk_page_delete(tree, sibling)
+					/* trace upwards */
+					return disk_non_leaf_remove(tree, parent, i+1)
+				}
+			}
+			/* deletion finishes */
+			return nil
+		} else {
+			if page_count(node) == 2 {
+				/* delete old root node */
+				if err = disk_set_parent(tree, disk_sub(tree, node, 0), 0); err != nil {
+					return err
+				}
+				tree.meta.root = disk_sub(tree, node, 0)
+				tree.meta.height--
+				disk_page_delete(tree, node)
+				return nil
+			}
+		}
+	}
+
+	/* simple deletion */
+	for remove < page_count(node)-2 {
+		disk_set_key(tree, node, remove, disk_key_at(tree, node, remove+1))
+		disk_set_sub(tree, node, remove+1, disk_sub(tree, node, remove+2))
+		remove++
+	}
+	page_set_count(node, page_count(node)-1)
+	return nil
+}
+
+func disk_leaf_remove[K, V any](tree *disk_tree[K, V], leaf *disk_page, key K) (V, error) {
+
+	var i, j, k int
+	var sibling *disk_page
+	var borrow int
+
+	var remove int = disk_search(tree, leaf, page_count(leaf), key)
+	if remove < 0 {
+		/* Not exist */
+		var zero V
+		return zero, ErrNotFound
+	}
+	var data V = disk_data(tree, leaf, remove)
+
+	if page_count(leaf) <= (tree.entries+1)/2 {
+		var parent_id pgid = page_parent(leaf)
+		if parent_id != 0 {
+			parent, err := disk_fetch(tree, parent_id, true)
+			if err != nil {
+				return data, err
+			}
+			/* find which sibling node with same parent to be borrowed from */
+			if sibling, borrow, i, err = disk_sibling(tree, parent, leaf); err != nil {
+				return data, err
+			}
+
+			/* locate parent node key to update later */
+			i = i - 1
+
+			if borrow == BORROW_FROM_LEFT {
+				if page_count(sibling) > (tree.entries+1)/2 {
+					/* right shift in leaf node */
+					for remove > 0 {
+						disk_leaf_move(tree, leaf, remove, leaf, remove-1)
+						remove--
+					}
+					/* borrow the last element from left sibling */
+					disk_leaf_move(tree, leaf, 0, sibling, page_count(sibling)-1)
+					page_set_count(sibling, page_count(sibling)-1)
+					/* update parent key */
+					disk_set_key(tree, parent, i, disk_key_at(tree, leaf, 0))
+				} else {
+					/* merge with left sibling */
+					for j, k = page_count(sibling), 0; k < page_count(leaf); k++ {
+						if k != remove {
+							disk_leaf_move(tree, sibling, j, leaf, k)
+							j++
+						}
+					}
+					page_set_count(sibling, j)
+					/* delete merged leaf */
+					page_set_next(sibling, page_next(leaf))
+					if page_next(leaf) != 0 {
+						next, err := disk_fetch(tree, page_next(leaf), true)
+						if err != nil {
+							return data, err
+						}
+						page_set_prev(next, sibling.id)
+					}
+					disk_page_delete(tree, leaf)
+					/* trace upwards */
+					return data, disk_non_leaf_remove(tree, parent, i)
+				}
+			} else {
+				/* remove element first in case of overflow during merging with sibling node */
+				for remove < page_count(leaf)-1 {
+					disk_leaf_move(tree, leaf, remove, leaf, remove+1)
+					remove++
+				}
+				page_set_count(leaf, page_count(leaf)-1)
+				if page_count(sibling) > (tree.entries+1)/2 {
+					/* borrow the first element from right sibling */
+					disk_leaf_move(tree, leaf, page_count(leaf), sibling, 0)
+					page_set_count(leaf, page_count(leaf)+1)
+					/* left shift in right sibling */
+					for j = 0; j < page_count(sibling)-1; j++ {
+						disk_leaf_move(tree, sibling, j, sibling, j+1)
+					}
+					page_set_count(sibling, page_count(sibling)-1)
+					/* update parent key */
+					disk_set_key(tree, parent, i+1, disk_key_at(tree, sibling, 0))
+				} else {
+					/* merge with right sibling */
+					for j, k = page_count(leaf), 0; k < page_count(sibling); j, k = j+1, k+1 {
+						disk_leaf_move(tree, leaf, j, sibling, k)
+					}
+					page_set_count(leaf, j)
+					/* delete right sibling */
+					page_set_next(leaf, page_next(sibling))
+					if page_next(sibling) != 0 {
+						next, err := disk_fetch(tree, page_next(sibling), true)
+						if err != nil {
+							return data, err
+						}
+						page_set_prev(next, leaf.id)
+					}
+					disk_page_delete(tree, sibling)
+					/* trace upwards */
+					return data, disk_non_leaf_remove(tree, parent, i+1)
+				}
+			}
+			/* deletion finishes */
+			return data, nil
+		} else {
+			if page_count(leaf) == 1 {
+				/* delete the only last node */
+				tree.meta.root = 0
+				tree.meta.height = 0
+				disk_page_delete(tree, leaf)
+				return data, nil
+			}
+		}
+	}
+
+	/* simple deletion */
+	for remove < page_count(leaf)-1 {
+		disk_leaf_move(tree, leaf, remove, leaf, remove+1)
+		remove++
+	}
+	page_set_count(leaf, page_count(leaf)-1)
+
+	return data, nil
+}
+
+/* log the pages and meta changed by a modification */
+func disk_tree_log[K, V any](tree *disk_tree[K, V], saved disk_meta) error {
+
+	pages := pager_changed(&tree.pager)
+	if len(pages) == 0 && tree.meta == saved {
+		return nil
+	}
+	for _, page := range pages {
+		page_seal(page)
+	}
+	lsn, err := wal_append(&tree.wal, tree.meta, pages)
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		page.lsn = lsn
+	}
+	return nil
+}
+
+/* finish a modification, logging it, or undoing it if err is set or logging fails */
+func disk_tree_end[K, V any](tree *disk_tree[K, V], saved disk_meta, err error) error {
+	if err == nil {
+		err = disk_tree_log(tree, saved)
+	}
+	if err == nil {
+		pager_commit(&tree.pager)
+		return nil
+	}
+	pager_abort(&tree.pager)
+	tree.meta = saved
+	return err
+}
+
+func disk_tree_put[K, V any](tree *disk_tree[K, V], key K, data V) (old V, replaced bool, err error) {
+
+	if tree.ro {
+		return old, false, ErrReadOnly
+	}
+	kb := make([]byte, tree.ksize)
+	vb := make([]byte, tree.vsize)
+	if err = tree.kc.Encode(kb, key); err != nil {
+		return old, false, err
+	}
+	if err = tree.vc.Encode(vb, data); err != nil {
+		return old, false, err
+	}
+
+	saved := tree.meta
+	defer func() {
+		err = disk_tree_end(tree, saved, err)
+	}()
+
+	ln, err := disk_tree_locate(tree, key, true)
+	if err != nil {
+		return old, false, err
+	}
+	if ln == nil {
+		if err = disk_leaf_insert_root(tree, kb, vb); err != nil {
+			return old, false, err
+		}
+		tree.meta.count++
+		return old, false, nil
+	}
+	if i := disk_search(tree, ln, page_count(ln), key); i >= 0 {
+		/* replace in place, the tree shape is unchanged */
+		old = disk_data(tree, ln, i)
+		disk_set_data(tree, ln, i, vb)
+		return old, true, nil
+	}
+	if err = disk_leaf_insert(tree, ln, key, kb, vb); err != nil {
+		return old, false, err
+	}
+	tree.meta.count++
+	return old, false, nil
+}
+
+func disk_tree_delete[K, V any](tree *disk_tree[K, V], key K) (data V, err error) {
+
+	if tree.ro {
+		return data, ErrReadOnly
+	}
+	saved := tree.meta
+	defer func() {
+		err = disk_tree_end(tree, saved, err)
+	}()
+
+	ln, err := disk_tree_locate(tree, key, true)
+	if err != nil {
+		return data, err
+	}
+	if ln == nil {
+		return data, ErrNotFound
+	}
+	if data, err = disk_leaf_remove(tree, ln, key); err != nil {
+		return data, err
+	}
+	tree.meta.count--
+	return data, nil
+}
+
+func disk_tree_get_range[K, V any](tree *disk_tree[K, V], min K, max K, flags int, fn func(key K, data V) bool) error {
+
+	var ln *disk_page
+	var i int
+	var err error
+
+	if flags&RANGE_NO_MIN != 0 {
+		ln, err = disk_tree_edge_leaf(tree, false)
+	} else {
+		ln, err = disk_tree_locate(tree, min, false)
+		if ln != nil {
+			i = disk_search(tree, ln, page_count(ln), min)
+			if i < 0 {
+				i = -i - 1
+			} else if flags&RANGE_EXCLUDE_MIN != 0 {
+				i++
+			}
+		}
+	}
+	for err == nil && ln != nil {
+		if i >= page_count(ln) {
+			/* continue along the leaf chain */
+			next := page_next(ln)
+			disk_release(tree, ln)
+			ln = nil
+			if next != 0 {
+				ln, err = disk_fetch(tree, next, false)
+				i = 0
+			}
+			continue
+		}
+		key := disk_key(tree, ln, i)
+		if flags&RANGE_NO_MAX == 0 {
+			c := tree.compare(key, max)
+			if c > 0 || (c == 0 && flags&RANGE_EXCLUDE_MAX != 0) {
+				break
+			}
+		}
+		if !fn(key, disk_data(tree, ln, i)) {
+			break
+		}
+		i++
+	}
+	if ln != nil {
+		disk_release(tree, ln)
+	}
+	return err
+}
+
+func disk_tree_get_range_reverse[K, V any](tree *disk_tree[K, V], max K, min K, flags int, fn func(key K, data V) bool) error {
+
+	var ln *disk_page
+	var i int
+	var err error
+
+	if flags&RANGE_NO_MAX != 0 {
+		ln, err = disk_tree_edge_leaf(tree, true)
+		if ln != nil {
+			i = page_count(ln) - 1
+		}
+	} else {
+		ln, err = disk_tree_locate(tree, max, false)
+		if ln != nil {
+			i = disk_search(tree, ln, page_count(ln), max)
+			if i < 0 {
+				i = -i - 2
+			} else if flags&RANGE_EXCLUDE_MAX != 0 {
+				i--
+			}
+		}
+	}
+	for err == nil && ln != nil {
+		if i < 0 {
+			/* continue backwards along the leaf chain */
+			prev := page_prev(ln)
+			disk_release(tree, ln)
+			ln = nil
+			if prev != 0 {
+				if ln, err = disk_fetch(tree, prev, false); err == nil {
+					i = page_count(ln) - 1
+				}
+			}
+			continue
+		}
+		key := disk_key(tree, ln, i)
+		if flags&RANGE_NO_MIN == 0 {
+			c := tree.compare(key, min)
+			if c < 0 || (c == 0 && flags&RANGE_EXCLUDE_MIN != 0) {
+				break
+			}
+		}
+		if !fn(key, disk_data(tree, ln, i)) {
+			break
+		}
+		i--
+	}
+	if ln != nil {
+		disk_release(tree, ln)
+	}
+	return err
+}
+
+func disk_meta_encode[K, V any](tree *disk_tree[K, V]) []byte {
+	buf := make([]byte, meta_size)
+	copy(buf, disk_magic)
+	binary.LittleEndian.PutUint32(buf[8:], META_VERSION)
+	binary.LittleEndian.PutUint32(buf[12:], PAGE_SIZE)
+	binary.LittleEndian.PutUint32(buf[16:], uint32(tree.ksize))
+	binary.LittleEndian.PutUint32(buf[20:], uint32(tree.vsize))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(tree.order))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(tree.entries))
+	binary.LittleEndian.PutUint64(buf[32:], uint64(tree.meta.root))
+	binary.LittleEndian.PutUint32(buf[40:], uint32(tree.meta.height))
+	binary.LittleEndian.PutUint64(buf[48:], tree.meta.count)
+	binary.LittleEndian.PutUint64(buf[56:], tree.meta.npages)
+	binary.LittleEndian.PutUint64(buf[64:], tree.lsn)
+	binary.LittleEndian.PutUint64(buf[72:], uint64(tree.meta.free))
+	binary.LittleEndian.PutUint64(buf[80:], tree.meta.nfree)
+	binary.LittleEndian.PutUint32(buf[meta_crc:], page_checksum(buf, meta_crc))
+	return buf
+}
+
+/* the offset of the older meta copy, the one the next write overwrites */
+func disk_meta_next[K, V any](tree *disk_tree[K, V]) int64 {
+	return int64(1-tree.slot) * META_SLOT
+}
+
+/* the largest order and entries whose nodes fit in a page */
+func disk_geometry(ksize int, vsize int) (int, int) {
+	return (PAGE_SIZE - PAGE_HEADER + ksize) / (ksize + 8), (PAGE_SIZE - PAGE_HEADER) / (ksize + vsize)
+}
+
+/* start a new file with an empty tree */
+func disk_tree_create[K, V any](tree *disk_tree[K, V], order int, entries int) error {
+
+	max_order, max_entries := disk_geometry(tree.ksize, tree.vsize)
+	if order == 0 {
+		order = max_order
+	}
+	if entries == 0 {
+		entries = max_entries
+	}
+	if order <= MIN_ORDER || order > max_order {
+		return fmt.Errorf("bplustree: order %d out of range for %d byte keys", order, tree.ksize)
+	}
+	if entries < MIN_ORDER || entries > max_entries {
+		return fmt.Errorf("bplustree: entries %d out of range for %d byte entries", entries, tree.ksize+tree.vsize)
+	}
+
+	tree.order = order
+	tree.entries = entries
+	tree.meta = disk_meta{npages: 1}
+	page := make([]byte, PAGE_SIZE)
+	meta := disk_meta_encode(tree)
+	copy(page, meta)
+	copy(page[META_SLOT:], meta)
+	if _, err := tree.pager.file.WriteAt(page, 0); err != nil {
+		return err
+	}
+	return tree.pager.file.Sync()
+}
+
+/* read the newer intact meta copy of an existing file */
+func disk_tree_load[K, V any](tree *disk_tree[K, V], size int64) error {
+
+	page := make([]byte, PAGE_SIZE)
+	if _, err := tree.pager.file.ReadAt(page, 0); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("%w: short meta page", ErrInvalidFile)
+		}
+		return err
+	}
+	var buf []byte
+	for slot, off := range []int{0, META_SLOT} {
+		meta := page[off : off+meta_size]
+		if string(meta[:8]) != disk_magic || binary.LittleEndian.Uint32(meta[meta_crc:]) != page_checksum(meta, meta_crc) {
+			continue
+		}
+		if buf == nil || binary.LittleEndian.Uint64(meta[64:]) > binary.LittleEndian.Uint64(buf[64:]) {
+			buf, tree.slot = meta, slot
+		}
+	}
+	if buf == nil {
+		if string(page[:8]) != disk_magic && string(page[META_SLOT:META_SLOT+8]) != disk_magic {
+			return fmt.Errorf("%w: bad magic", ErrInvalidFile)
+		}
+		if v := binary.LittleEndian.Uint32(page[8:]); v != META_VERSION {
+			return fmt.Errorf("%w: unsupported version %d", ErrInvalidFile, v)
+		}
+		return ErrCorruptPage{PageID: 0}
+	}
+	if v := binary.LittleEndian.Uint32(buf[8:]); v != META_VERSION {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidFile, v)
+	}
+	if n := binary.LittleEndian.Uint32(buf[12:]); n != PAGE_SIZE {
+		return fmt.Errorf("%w: page size %d", ErrInvalidFile, n)
+	}
+	ksize := int(binary.LittleEndian.Uint32(buf[16:]))
+	vsize := int(binary.LittleEndian.Uint32(buf[20:]))
+	if ksize != tree.ksize || vsize != tree.vsize {
+		return fmt.Errorf("%w: written with %d byte keys and %d byte values, codecs have %d and %d",
+			ErrInvalidFile, ksize, vsize, tree.ksize, tree.vsize)
+	}
+	tree.order = int(binary.LittleEndian.Uint32(buf[24:]))
+	tree.entries = int(binary.LittleEndian.Uint32(buf[28:]))
+	max_order, max_entries := disk_geometry(ksize, vsize)
+	if tree.order <= MIN_ORDER || tree.order > max_order || tree.entries < MIN_ORDER || tree.entries > max_entries {
+		return fmt.Errorf("%w: bad order or entries", ErrInvalidFile)
+	}
+	tree.meta = disk_meta{
+		root:   pgid(binary.LittleEndian.Uint64(buf[32:])),
+		height: int(binary.LittleEndian.Uint32(buf[40:])),
+		count:  binary.LittleEndian.Uint64(buf[48:]),
+		npages: binary.LittleEndian.Uint64(buf[56:]),
+		free:   pgid(binary.LittleEndian.Uint64(buf[72:])),
+		nfree:  binary.LittleEndian.Uint64(buf[80:]),
+	}
+	tree.lsn = binary.LittleEndian.Uint64(buf[64:])
+	if tree.meta.npages == 0 || uint64(size) < tree.meta.npages*PAGE_SIZE {
+		return fmt.Errorf("%w: file is truncated", ErrInvalidFile)
+	}
+	if (tree.meta.root == 0) != (tree.meta.height == 0) || uint64(tree.meta.root) >= tree.meta.npages {
+		return fmt.Errorf("%w: bad root", ErrInvalidFile)
+	}
+	if uint64(tree.meta.free) >= tree.meta.npages || tree.meta.nfree >= tree.meta.npages || (tree.meta.free == 0) != (tree.meta.nfree == 0) {
+		return fmt.Errorf("%w: bad free list", ErrInvalidFile)
+	}
+	return nil
+}
+
+func disk_tree_open[K, V any](path string, compare func(a, b K) int, kc Codec[K], vc Codec[V], c config) (*disk_tree[K, V], error) {
+
+	if c.readonly {
+		return disk_tree_open_ro(path, compare, kc, vc, c)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(path+"-wal", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	tree, err := disk_tree_init(file, log, compare, kc, vc, c)
+	if err != nil {
+		file.Close()
+		log.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+/*
+ * Open the tree at path for reading only, its pages mapped straight from
+ * the file where the platform allows and read through the cache where it
+ * does not. The log is left alone, so it must not hold anything the file
+ * does not.
+ */
+func disk_tree_open_ro[K, V any](path string, compare func(a, b K) int, kc Codec[K], vc Codec[V], c config) (*disk_tree[K, V], error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tree := disk_tree_new(file, compare, kc, vc, c)
+	tree.ro = true
+	info, err := file.Stat()
+	if err == nil {
+		err = disk_tree_load(tree, info.Size())
+	}
+	if err == nil {
+		err = wal_check(path+"-wal", tree.lsn)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() <= math.MaxInt {
+		if mapped, err := mmap_file(file, int(info.Size())); err == nil {
+			tree.pager.mapped = mapped
+		}
+	}
+	return tree, nil
+}
+
+func disk_tree_new[K, V any](file disk_file, compare func(a, b K) int, kc Codec[K], vc Codec[V], c config) *disk_tree[K, V] {
+	tree := &disk_tree[K, V]{
+		pager:   pager{file: file, pages: make(map[pgid]*pager_pin)},
+		ksize:   kc.Size(),
+		vsize:   vc.Size(),
+		kc:      kc,
+		vc:      vc,
+		compare: compare,
+	}
+	pool_init(&tree.pager.pool, file, &tree.wal, c.cache)
+	return tree
+}
+
+/* set up a tree over its file and log, replaying the log into the file */
+func disk_tree_init[K, V any](file disk_file, log disk_file, compare func(a, b K) int, kc Codec[K], vc Codec[V], c config) (*disk_tree[K, V], error) {
+
+	tree := disk_tree_new(file, compare, kc, vc, c)
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		err = disk_tree_create(tree, c.order, c.entries)
+	} else {
+		err = disk_tree_load(tree, info.Size())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var replayed bool
+	err = wal_open(&tree.wal, log, c.sync, tree.lsn, func(meta disk_meta, ids []pgid, images [][]byte) error {
+		for i, id := range ids {
+			if id == 0 || uint64(id) >= meta.npages {
+				return fmt.Errorf("%w: log record for page %d out of range", ErrInvalidFile, id)
+			}
+			if _, err := file.WriteAt(images[i], int64(id)*PAGE_SIZE); err != nil {
+				return err
+			}
+		}
+		tree.meta = meta
+		replayed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		/* the file now holds every record */
+		if err := disk_tree_checkpoint(tree); err != nil {
+			return nil, err
+		}
+	}
+	wal_start(&tree.wal)
+	return tree, nil
+}
+
+/* make every modification so far durable */
+func disk_tree_sync[K, V any](tree *disk_tree[K, V]) error {
+	return wal_sync(&tree.wal)
+}
+
+/* write every change to the file and empty the log */
+func disk_tree_checkpoint[K, V any](tree *disk_tree[K, V]) error {
+	if tree.ro {
+		return ErrReadOnly
+	}
+	if wal_size(&tree.wal) == WAL_HEADER {
+		/* nothing changed since the last checkpoint */
+		return nil
+	}
+	if err := wal_sync(&tree.wal); err != nil {
+		return err
+	}
+	tree.lsn = tree.wal.lsn
+	if err := pager_flush(&tree.pager, disk_meta_encode(tree), disk_meta_next(tree)); err != nil {
+		return err
+	}
+	if err := tree.pager.file.Sync(); err != nil {
+		return err
+	}
+	tree.slot = 1 - tree.slot
+	return wal_reset(&tree.wal)
+}
+
+/*
+ * The live pages, those reachable from the root, and the node to the
+ * left of each on its level, whose next link points at it.
+ */
+func disk_tree_walk[K, V any](tree *disk_tree[K, V]) ([]bool, []pgid, int, error) {
+
+	live := make([]bool, tree.meta.npages)
+	left := make([]pgid, tree.meta.npages)
+	var n int
+	var level []pgid
+	if tree.meta.root != 0 {
+		level = append(level, tree.meta.root)
+	}
+	for len(level) > 0 {
+		var below []pgid
+		for i, id := range level {
+			page, err := disk_fetch(tree, id, false)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			if page_kind(page) == BPLUS_TREE_NON_LEAF {
+				for j := 0; j < page_count(page); j++ {
+					below = append(below, disk_sub(tree, page, j))
+				}
+			}
+			disk_release(tree, page)
+			if live[id] {
+				return nil, nil, 0, fmt.Errorf("%w: page %d is linked twice", ErrInvalidFile, id)
+			}
+			live[id] = true
+			if i > 0 {
+				left[id] = level[i-1]
+			}
+			n++
+		}
+		level = below
+	}
+	return live, left, n, nil
+}
+
+/* move the node at page id to the unused page to, relinking its parent, neighbours and children */
+func disk_tree_move[K, V any](tree *disk_tree[K, V], id pgid, to pgid, left []pgid) (err error) {
+
+	saved := tree.meta
+	defer func() {
+		err = disk_tree_end(tree, saved, err)
+	}()
+
+	page, err := disk_fetch(tree, id, true)
+	if err != nil {
+		return err
+	}
+	dest, err := pager_claim(&tree.pager, &tree.meta, to)
+	if err != nil {
+		return err
+	}
+	copy(dest.buf, page.buf)
+	dest.dirty = true
+
+	if parent := page_parent(page); parent == 0 {
+		tree.meta.root = to
+	} else {
+		pn, err := disk_fetch(tree, parent, true)
+		if err != nil {
+			return err
+		}
+		i, err := disk_sub_index(tree, pn, id)
+		if err != nil {
+			return err
+		}
+		disk_set_sub(tree, pn, i, to)
+	}
+	if left[id] != 0 {
+		prev, err := disk_fetch(tree, left[id], true)
+		if err != nil {
+			return err
+		}
+		page_set_next(prev, to)
+	}
+	if next := page_next(page); next != 0 {
+		if page_kind(page) == BPLUS_TREE_LEAF {
+			nn, err := disk_fetch(tree, next, true)
+			if err != nil {
+				return err
+			}
+			page_set_prev(nn, to)
+		}
+		left[next] = to
+	}
+	left[to] = left[id]
+	if page_kind(page) == BPLUS_TREE_NON_LEAF {
+		for i := 0; i < page_count(page); i++ {
+			if err = disk_set_parent(tree, disk_sub(tree, page, i), to); err != nil {
+				return err
+			}
+		}
+	}
+
+	/* past the end of the compacted file, off the free list */
+	clear(page.buf)
+	page_set_kind(page, PAGE_FREE)
+	return nil
+}
+
+/* log a change of meta alone */
+func disk_tree_set_meta[K, V any](tree *disk_tree[K, V], meta disk_meta) error {
+	saved := tree.meta
+	tree.meta = meta
+	return disk_tree_end(tree, saved, nil)
+}
+
+/*
+ * Rewrite the file densely: every live node past the first n pages,
+ * where n is the number of live nodes, moves into an unused page among
+ * them, one logged modification at a time, and the file is cut after
+ * them once checkpointed. Free pages and those lost to a crash or to
+ * files written before the free list are all reclaimed.
+ */
+func disk_tree_compact[K, V any](tree *disk_tree[K, V]) error {
+
+	if tree.ro {
+		return ErrReadOnly
+	}
+	live, left, n, err := disk_tree_walk(tree)
+	if err != nil {
+		return err
+	}
+	end := pgid(n + 1)
+
+	/* every page on the free list is either about to be filled or cut off */
+	meta := tree.meta
+	meta.free, meta.nfree = 0, 0
+	if err := disk_tree_set_meta(tree, meta); err != nil {
+		return err
+	}
+	var hole pgid = 1
+	for id := end; uint64(id) < tree.meta.npages; id++ {
+		if !live[id] {
+			continue
+		}
+		for live[hole] {
+			hole++
+		}
+		if err := disk_tree_move(tree, id, hole, left); err != nil {
+			return err
+		}
+		live[hole] = true
+	}
+	meta = tree.meta
+	meta.npages = uint64(end)
+	if err := disk_tree_set_meta(tree, meta); err != nil {
+		return err
+	}
+
+	if err := disk_tree_checkpoint(tree); err != nil {
+		return err
+	}
+	pool_truncate(&tree.pager.pool, end)
+	if err := tree.pager.file.Truncate(int64(end) * PAGE_SIZE); err != nil {
+		return err
+	}
+	return tree.pager.file.Sync()
+}
+
+func disk_tree_close[K, V any](tree *disk_tree[K, V]) error {
+	if tree.ro {
+		var err error
+		if tree.pager.mapped != nil {
+			err = munmap_file(tree.pager.mapped)
+			tree.pager.mapped = nil
+		}
+		if cerr := tree.pager.file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	err := disk_tree_checkpoint(tree)
+	if cerr := wal_close(&tree.wal); err == nil {
+		err = cerr
+	}
+	if cerr := tree.pager.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// DiskTree is a B+ tree stored in a file, mapping keys of type K to
+// values of type V. Nodes are kept in fixed-size pages of the file and
+// read as they are needed, so a tree persists across Close and Open and
+// may be far larger than memory. Keys and values are stored by a Codec
+// each. The zero value is not usable; open trees with Open or OpenFunc.
+//
+// Recently used pages are cached in memory, see WithCacheSize. Every
+// modification is first appended to a write-ahead log kept next to the
+// file, at path + "-wal", and the pages it changed are written back to
+// the file later, as the cache evicts them and by Close. Opening a tree
+// replays its log, so a crash at any point, even in the middle of a
+// split or merge, loses at most the modifications the SyncPolicy had
+// not yet forced to disk. Checkpoints write the changed pages to the
+// file and empty the log, by default whenever it outgrows
+// DEFAULT_CHECKPOINT_SIZE, see WithCheckpointSize, WithCheckpointInterval
+// and Checkpoint.
+//
+// Every method that touches the file returns an error if reading or
+// writing it fails, or if the pages it reads are not valid; a
+// modification that fails leaves the tree as it was.
+//
+// A DiskTree is safe for concurrent use by multiple goroutines. Lookups
+// and scans run in parallel; modifications are serialized. A file must
+// not be opened by more than one DiskTree at a time.
+type DiskTree[K, V any] struct {
+	mu    sync.RWMutex
+	tree  *disk_tree[K, V]
+	limit int64 // log size that triggers a checkpoint, 0 for none
+	err   error // of a failed background checkpoint, not yet reported
+	kick  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// DEFAULT_CHECKPOINT_SIZE is the size, in bytes, of the write-ahead log
+// past which a DiskTree checkpoints unless WithCheckpointSize says
+// otherwise.
+const DEFAULT_CHECKPOINT_SIZE = 16 << 20
+
+// Open opens the tree stored in the file at path, creating an empty one
+// if the file does not exist or is empty, with keys ordered naturally
+// and stored by kc and values stored by vc. The codecs must encode to
+// the same sizes as those the file was created with.
+//
+// WithOrder and WithEntries only apply when the file is created. By
+// default nodes are as large as a page allows, and Open returns an error
+// if the requested size does not fit. WithCacheSize bounds the page
+// cache, DEFAULT_CACHE_SIZE by default, WithSyncPolicy how often the
+// log is forced to disk, SyncAlways by default, and WithCheckpointSize
+// and WithCheckpointInterval when the tree checkpoints in the
+// background. WithReadOnly opens an existing file read-only and mapped
+// into memory, for sharing an index between processes.
+// WithLatchCrabbing has no effect.
+func Open[K cmp.Ordered, V any](path string, kc Codec[K], vc Codec[V], opts ...Option) (*DiskTree[K, V], error) {
+	return OpenFunc[K, V](path, cmp.Compare[K], kc, vc, opts...)
+}
+
+// OpenFunc is like Open but orders keys with compare, which has the same
+// contract as for NewFunc. A file must always be opened with the same
+// ordering.
+func OpenFunc[K, V any](path string, compare func(a, b K) int, kc Codec[K], vc Codec[V], opts ...Option) (*DiskTree[K, V], error) {
+	c := config{
+		cache:      DEFAULT_CACHE_SIZE,
+		checkpoint: DEFAULT_CHECKPOINT_SIZE,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	tree, err := disk_tree_open(path, compare, kc, vc, c)
+	if err != nil {
+		return nil, err
+	}
+	t := &DiskTree[K, V]{tree: tree}
+	t.start(c)
+	return t, nil
+}
+
+// start launches the checkpointer if c asks for background checkpoints.
+func (t *DiskTree[K, V]) start(c config) {
+	t.limit = int64(max(c.checkpoint, 0))
+	if !c.readonly && (t.limit > 0 || c.every > 0) {
+		t.kick = make(chan struct{}, 1)
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.checkpointer(c.every)
+	}
+}
+
+// checkpointer checkpoints the tree every interval, if positive, and
+// whenever a modification finds the log has outgrown t.limit, until
+// Close. A checkpoint that fails leaves the log as it was and is
+// retried on the next trigger; its error is kept in t.err until the
+// next Put, Delete or Close reports it.
+func (t *DiskTree[K, V]) checkpointer(interval time.Duration) {
+	defer close(t.done)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-t.kick:
+		case <-t.stop:
+			return
+		}
+		t.mu.Lock()
+		if t.tree != nil {
+			if err := disk_tree_checkpoint(t.tree); err != nil && t.err == nil {
+				t.err = err
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// modified wakes the checkpointer if the log has outgrown t.limit. It
+// is called with t.mu held.
+func (t *DiskTree[K, V]) modified() {
+	if t.limit > 0 && wal_size(&t.tree.wal) >= t.limit {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// failed returns the error of a background checkpoint that failed since
+// it was last called, if any. It is called with t.mu held.
+func (t *DiskTree[K, V]) failed() error {
+	err := t.err
+	t.err = nil
+	return err
+}
+
+// Get returns the value stored under key and reports whether key was
+// present.
+func (t *DiskTree[K, V]) Get(key K) (V, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tree == nil {
+		var zero V
+		return zero, false, ErrClosed
+	}
+	return disk_tree_search(t.tree, key)
+}
+
+// Put stores value under key, overwriting any existing value. It
+// returns the previous value and reports whether one was replaced. Put
+// fails without changing the tree if key or value cannot be encoded, or
+// to report that a background checkpoint failed since the last Put,
+// Delete or Close.
+func (t *DiskTree[K, V]) Put(key K, value V) (V, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tree == nil {
+		var zero V
+		return zero, false, ErrClosed
+	}
+	if err := t.failed(); err != nil {
+		var zero V
+		return zero, false, err
+	}
+	old, ok, err := disk_tree_put(t.tree, key, value)
+	if err == nil {
+		t.modified()
+	}
+	return old, ok, err
+}
+
+// Delete removes key from the tree, returning the value it held and
+// whether it was present. Like Put, it fails without changing the tree
+// to report a failed background checkpoint.
+func (t *DiskTree[K, V]) Delete(key K) (V, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tree == nil {
+		var zero V
+		return zero, false, ErrClosed
+	}
+	if err := t.failed(); err != nil {
+		var zero V
+		return zero, false, err
+	}
+	value, err := disk_tree_delete(t.tree, key)
+	if err == ErrNotFound {
+		return value, false, nil
+	}
+	if err == nil {
+		t.modified()
+	}
+	return value, err == nil, err
+}
+
+// Len returns the number of keys stored in the tree.
+func (t *DiskTree[K, V]) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tree == nil {
+		return 0
+	}
+	return int(t.tree.meta.count)
+}
+
+// scan is Tree.scan for a DiskTree, reading a leaf's worth of entries
+// at a time under the reader lock.
+func (t *DiskTree[K, V]) scan(min, max K, flags int, reverse bool, fn func(key K, value V) bool) error {
+	var keys []K
+	var values []V
+	for {
+		keys, values = keys[:0], values[:0]
+		t.mu.RLock()
+		if t.tree == nil {
+			t.mu.RUnlock()
+			return ErrClosed
+		}
+		batch := t.tree.entries
+		collect := func(key K, data V) bool {
+			keys = append(keys, key)
+			values = append(values, data)
+			return len(keys) < batch
+		}
+		var err error
+		if reverse {
+			err = disk_tree_get_range_reverse(t.tree, max, min, flags, collect)
+		} else {
+			err = disk_tree_get_range(t.tree, min, max, flags, collect)
+		}
+		t.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		for i := range keys {
+			if !fn(keys[i], values[i]) {
+				return nil
+			}
+		}
+		if len(keys) < batch {
+			return nil
+		}
+		if reverse {
+			max = keys[len(keys)-1]
+			flags = flags&^RANGE_NO_MAX | RANGE_EXCLUDE_MAX
+		} else {
+			min = keys[len(keys)-1]
+			flags = flags&^RANGE_NO_MIN | RANGE_EXCLUDE_MIN
+		}
+	}
+}
+
+// AscendRange calls fn for every key between lo and hi, in ascending
+// order, until fn returns false, with the same bounds as
+// Tree.AscendRange. fn may modify the tree.
+func (t *DiskTree[K, V]) AscendRange(lo, hi K, fn func(key K, value V) bool, opts ...RangeOption) error {
+	return t.scan(lo, hi, rangeFlags(opts), false, fn)
+}
+
+// Ascend calls fn for every key in ascending order until fn returns
+// false.
+func (t *DiskTree[K, V]) Ascend(fn func(key K, value V) bool) error {
+	var zero K
+	return t.scan(zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, false, fn)
+}
+
+// Descend calls fn for every key in descending order until fn returns
+// false.
+func (t *DiskTree[K, V]) Descend(fn func(key K, value V) bool) error {
+	var zero K
+	return t.scan(zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, true, fn)
+}
+
+// DescendRange calls fn for every key between hi and lo, in descending
+// order, until fn returns false, with the same bounds as
+// Tree.DescendRange.
+func (t *DiskTree[K, V]) DescendRange(hi, lo K, fn func(key K, value V) bool, opts ...RangeOption) error {
+	return t.scan(lo, hi, rangeFlags(opts), true, fn)
+}
+
+// CacheStats returns the page cache counters accumulated since Open.
+func (t *DiskTree[K, V]) CacheStats() CacheStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.tree == nil {
+		return CacheStats{}
+	}
+	return pool_stats(&t.tree.pager.pool)
+}
+
+// Sync forces the log to stable storage, making every modification so
+// far durable whatever the SyncPolicy.
+func (t *DiskTree[K, V]) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tree == nil {
+		return ErrClosed
+	}
+	return disk_tree_sync(t.tree)
+}
+
+// Checkpoint writes every cached change to the file, flushes it to
+// stable storage and empties the write-ahead log, which otherwise keeps
+// growing until the next checkpoint in the background. Reopening the
+// tree after a crash only replays what was logged since the last
+// checkpoint.
+func (t *DiskTree[K, V]) Checkpoint() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tree == nil {
+		return ErrClosed
+	}
+	return disk_tree_checkpoint(t.tree)
+}
+
+// Compact rewrites the file densely and shrinks it to the pages the tree
+// uses. Pages freed by merges are reused by later insertions anyway, so
+// Compact is only worth calling after deleting a large part of the
+// tree. It moves nodes one logged modification at a time, so a crash
+// leaves the tree intact, and blocks every other use of the tree until
+// it is done.
+func (t *DiskTree[K, V]) Compact() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tree == nil {
+		return ErrClosed
+	}
+	return disk_tree_compact(t.tree)
+}
+
+// Close checkpoints the tree and closes its file and log. It returns
+// the error of a failed background checkpoint not yet reported by Put
+// or Delete. The tree must not be used after Close.
+func (t *DiskTree[K, V]) Close() error {
+	t.mu.Lock()
+	if t.tree == nil {
+		t.mu.Unlock()
+		return ErrClosed
+	}
+	err := disk_tree_close(t.tree)
+	if ferr := t.failed(); ferr != nil {
+		err = ferr
+	}
+	t.tree = nil
+	t.mu.Unlock()
+	if t.stop != nil {
+		close(t.stop)
+		<-t.done
+	}
+	return err
+}
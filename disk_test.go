@@ -0,0 +1,462 @@
+package bplustree
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* check a disk tree holds exactly the model, and is sound */
+func disk_test_compare(t *testing.T, tree *DiskTree[int64, int64], model map[int64]int64) {
+	t.Helper()
+
+	n, prev := 0, int64(-1)
+	err := tree.Ascend(func(key, value int64) bool {
+		if want, ok := model[key]; !ok || key <= prev || value != want {
+			t.Fatalf("Ascend gave %d=%d after %d, want %d, %v", key, value, prev, want, ok)
+		}
+		prev = key
+		n++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(model) || tree.Len() != len(model) {
+		t.Fatalf("Ascend gave %d keys, Len() = %d, want %d", n, tree.Len(), len(model))
+	}
+	for key, want := range model {
+		if value, ok, err := tree.Get(key); err != nil || !ok || value != want {
+			t.Fatalf("Get(%d) = %d, %v, %v, want %d", key, value, ok, err, want)
+		}
+	}
+	violations, err := tree.Check()
+	if err != nil || len(violations) != 0 {
+		t.Fatalf("Check() = %v, %v", violations, err)
+	}
+}
+
+func TestDiskTreeModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	open := func(opts ...Option) *DiskTree[int64, int64] {
+		t.Helper()
+		tree, err := Open(path, Int64Codec(), Int64Codec(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+
+	/* no cache, so every page goes through the file on every operation */
+	tree := open(WithOrder(4), WithEntries(3), WithCacheSize(0))
+	model := make(map[int64]int64)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := int64(r.Intn(2000))
+		if r.Intn(3) > 0 {
+			old, replaced, err := tree.Put(key, int64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, ok := model[key]; replaced != ok || old != want {
+				t.Fatalf("Put(%d) = %d, %v, want %d, %v", key, old, replaced, want, ok)
+			}
+			model[key] = int64(i)
+		} else {
+			value, ok, err := tree.Delete(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, found := model[key]; ok != found || value != want {
+				t.Fatalf("Delete(%d) = %d, %v, want %d, %v", key, value, ok, want, found)
+			}
+			delete(model, key)
+		}
+		if i%4000 == 0 {
+			disk_test_compare(t, tree, model)
+		}
+	}
+	disk_test_compare(t, tree, model)
+	if stats := tree.CacheStats(); stats.Evictions == 0 || stats.Misses == 0 {
+		t.Fatalf("CacheStats() = %+v with no cache", stats)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* the geometry comes from the file, not the options */
+	tree = open(WithCacheSize(0))
+	disk_test_compare(t, tree, model)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree = open(WithReadOnly())
+	disk_test_compare(t, tree, model)
+	if _, _, err := tree.Put(0, 0); err != ErrReadOnly {
+		t.Fatalf("Put on a read-only tree = %v, want ErrReadOnly", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskTreeReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := Open(path, Int64Codec(), Int64Codec(), WithOrder(4), WithEntries(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 500; i++ {
+		if _, _, err := tree.Put(i, -i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mapped := range []bool{true, false} {
+		tree, err := Open(path, Int64Codec(), Int64Codec(), WithReadOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mapped && tree.tree.pager.mapped != nil {
+			/* as if the platform could not map the file, pages come through the cache */
+			if err := munmap_file(tree.tree.pager.mapped); err != nil {
+				t.Fatal(err)
+			}
+			tree.tree.pager.mapped = nil
+		}
+		if value, ok, err := tree.Get(250); err != nil || !ok || value != -250 {
+			t.Fatalf("mapped %v: Get(250) = %d, %v, %v", mapped, value, ok, err)
+		}
+		n := int64(100)
+		err = tree.AscendRange(100, 200, func(key, value int64) bool {
+			if key != n || value != -n {
+				t.Fatalf("mapped %v: AscendRange gave %d=%d, want %d", mapped, key, value, n)
+			}
+			n++
+			return true
+		})
+		if err != nil || n != 201 {
+			t.Fatalf("mapped %v: AscendRange(100, 200) = %v, stopped before %d", mapped, err, n)
+		}
+		if err := tree.Checkpoint(); err != ErrReadOnly {
+			t.Fatalf("mapped %v: Checkpoint on a read-only tree = %v, want ErrReadOnly", mapped, err)
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatalf("mapped %v: Close() = %v", mapped, err)
+		}
+	}
+}
+
+func TestDiskTreeCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := Open(path, Int64Codec(), Int64Codec(), WithOrder(8), WithEntries(8), WithSyncPolicy(SyncNever))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := make(map[int64]int64)
+	for i := int64(0); i < 20000; i++ {
+		tree.Put(i, i)
+		model[i] = i
+	}
+	/* a mass delete leaves most pages on the free list */
+	for i := int64(0); i < 20000; i++ {
+		if i%20 != 0 {
+			tree.Delete(i)
+			delete(model, i)
+		}
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.tree.meta.nfree == 0 {
+		t.Fatal("no pages freed by the delete")
+	}
+	live := int64(tree.tree.meta.npages-tree.tree.meta.nfree) * PAGE_SIZE
+
+	if err := tree.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* every live page moved into a hole and the tail cut off */
+	if after.Size() != live || tree.tree.meta.nfree != 0 || after.Size() >= before.Size() {
+		t.Fatalf("Compact left %d bytes with %d pages free, from %d bytes holding %d live", after.Size(), tree.tree.meta.nfree, before.Size(), live)
+	}
+	disk_test_compare(t, tree, model)
+
+	/* and the compacted file reopens whole */
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tree, err = Open(path, Int64Codec(), Int64Codec()); err != nil {
+		t.Fatal(err)
+	}
+	disk_test_compare(t, tree, model)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskTreeStrings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := Open(path, StringCodec(16), Float64Codec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := []string{"", "a", "kiwi", "banana", "sixteen-bytes-ok"}
+	for i, w := range words {
+		if _, _, err := tree.Put(w, float64(i)+0.5); err != nil {
+			t.Fatalf("Put(%q) = %v", w, err)
+		}
+	}
+	if _, _, err := tree.Put("seventeen-bytes!!", 0); err == nil {
+		t.Fatal("Put of a key longer than the codec allows succeeded")
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path, StringCodec(8), Float64Codec()); !errors.Is(err, ErrInvalidFile) {
+		t.Fatalf("Open with a smaller key codec = %v, want ErrInvalidFile", err)
+	}
+	tree, err = Open(path, StringCodec(16), Float64Codec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if tree.Len() != len(words) {
+		t.Fatalf("Len() = %d after reopening, want %d", tree.Len(), len(words))
+	}
+	for i, w := range words {
+		if value, ok, err := tree.Get(w); err != nil || !ok || value != float64(i)+0.5 {
+			t.Fatalf("Get(%q) = %v, %v, %v after reopening", w, value, ok, err)
+		}
+	}
+}
+
+/* wait for cond, checked with the tree locked, to hold */
+func disk_test_wait(t *testing.T, tree *DiskTree[int64, int64], what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		tree.mu.RLock()
+		ok := cond()
+		tree.mu.RUnlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDiskTreeCheckpointTriggers(t *testing.T) {
+	const limit = 64 << 10
+	tests := []struct {
+		name string
+		opts []Option
+		done func(size int64) bool
+	}{
+		/* each record holds at least one page, so the log passes the limit many times over */
+		{"size", []Option{WithCheckpointSize(limit)}, func(size int64) bool { return size < limit }},
+		{"interval", []Option{WithCheckpointSize(0), WithCheckpointInterval(time.Millisecond)}, func(size int64) bool { return size == WAL_HEADER }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tree.db")
+			tree, err := Open(path, Int64Codec(), Int64Codec(), append(tt.opts, WithSyncPolicy(SyncNever))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			for i := int64(0); i < 100; i++ {
+				if _, _, err := tree.Put(i, i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			disk_test_wait(t, tree, "a checkpoint", func() bool { return tt.done(wal_size(&tree.tree.wal)) })
+		})
+	}
+
+	/* with neither trigger the log only empties on Checkpoint */
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := Open(path, Int64Codec(), Int64Codec(), WithCheckpointSize(0), WithSyncPolicy(SyncNever))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := int64(0); i < 100; i++ {
+		if _, _, err := tree.Put(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size := wal_size(&tree.tree.wal); size < 100*PAGE_SIZE {
+		t.Fatalf("the log holds %d bytes after 100 records with no checkpoint", size)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if size := wal_size(&tree.tree.wal); size != WAL_HEADER {
+		t.Fatalf("the log holds %d bytes after Checkpoint", size)
+	}
+}
+
+var errDiskTest = errors.New("write failed")
+
+/* a tree file whose writes fail while fail is set */
+type disk_test_file struct {
+	*os.File
+	fail atomic.Bool
+}
+
+func (f *disk_test_file) WriteAt(p []byte, off int64) (int, error) {
+	if f.fail.Load() {
+		return 0, errDiskTest
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func TestDiskTreeCheckpointError(t *testing.T) {
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, "tree.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.Create(filepath.Join(dir, "tree.db-wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &disk_test_file{File: file}
+	c := config{cache: DEFAULT_CACHE_SIZE, every: time.Millisecond}
+	dt, err := disk_tree_init(f, log, cmp.Compare[int64], Int64Codec(), Int64Codec(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := &DiskTree[int64, int64]{tree: dt}
+	tree.start(c)
+
+	if _, _, err := tree.Put(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	f.fail.Store(true)
+	disk_test_wait(t, tree, "a failed checkpoint", func() bool { return tree.err != nil })
+
+	/* the next modification reports the failure instead of going ahead, once */
+	f.fail.Store(false)
+	if _, _, err := tree.Put(2, 2); !errors.Is(err, errDiskTest) {
+		t.Fatalf("Put after a failed checkpoint = %v, want %v", err, errDiskTest)
+	}
+	if _, ok, _ := tree.Get(2); ok {
+		t.Fatal("the Put that reported a failed checkpoint stored its key")
+	}
+	if _, _, err := tree.Put(2, 2); err != nil {
+		t.Fatalf("Put after the failure was reported = %v", err)
+	}
+	f.fail.Store(true)
+	disk_test_wait(t, tree, "a failed checkpoint", func() bool { return tree.err != nil })
+	f.fail.Store(false)
+	if _, _, err := tree.Delete(2); !errors.Is(err, errDiskTest) {
+		t.Fatalf("Delete after a failed checkpoint = %v, want %v", err, errDiskTest)
+	}
+
+	/* Close reports it too, though its own checkpoint succeeds */
+	f.fail.Store(true)
+	disk_test_wait(t, tree, "a failed checkpoint", func() bool { return tree.err != nil })
+	f.fail.Store(false)
+	if err := tree.Close(); !errors.Is(err, errDiskTest) {
+		t.Fatalf("Close after a failed checkpoint = %v, want %v", err, errDiskTest)
+	}
+}
+func TestDiskTreeCorruptPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := Open(path, Int64Codec(), Int64Codec(), WithOrder(8), WithEntries(8), WithSyncPolicy(SyncNever))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3000; i++ {
+		tree.Put(i, i)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, readonly := range []bool{false, true} {
+		for _, victim := range []pgid{5, 77, 200} {
+			/* flip one bit of one key, leaving the page's checksum stale */
+			damaged := bytes.Clone(image)
+			damaged[int(victim)*PAGE_SIZE+PAGE_HEADER+3] ^= 0x40
+			if err := os.WriteFile(path, damaged, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var opts []Option
+			if readonly {
+				opts = append(opts, WithReadOnly())
+			}
+			tree, err := Open(path, Int64Codec(), Int64Codec(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tree.Ascend(func(key, value int64) bool { return true })
+			var corrupt ErrCorruptPage
+			if !errors.As(err, &corrupt) || corrupt.PageID != uint64(victim) || !errors.Is(err, ErrInvalidFile) {
+				t.Fatalf("read-only %v: Ascend over damaged page %d = %v", readonly, victim, err)
+			}
+			violations, err := tree.Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(violations) == 0 || violations[0].PageID != uint64(victim) {
+				t.Fatalf("read-only %v: Check() = %v, want page %d first", readonly, violations, victim)
+			}
+			tree.Close()
+		}
+	}
+
+	/*
+	 * damaging the newer copy of the meta falls back on the older, which
+	 * the emptied log no longer follows, and damaging both leaves nothing
+	 */
+	newer := 0
+	if binary.LittleEndian.Uint64(image[META_SLOT+64:]) > binary.LittleEndian.Uint64(image[64:]) {
+		newer = META_SLOT
+	}
+	damaged := bytes.Clone(image)
+	damaged[newer+50] ^= 1
+	if err := os.WriteFile(path, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, Int64Codec(), Int64Codec()); !errors.Is(err, ErrInvalidFile) {
+		t.Fatalf("Open with the newer meta damaged = %v", err)
+	}
+	damaged[META_SLOT-newer+50] ^= 1
+	if err := os.WriteFile(path, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt ErrCorruptPage
+	if _, err := Open(path, Int64Codec(), Int64Codec()); !errors.As(err, &corrupt) || corrupt.PageID != 0 {
+		t.Fatalf("Open with both copies of the meta damaged = %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrKeyExists is returned when inserting a key that is already
+	// present in the tree.
+	ErrKeyExists = errors.New("bplustree: key already exists")
+
+	// ErrNotFound is returned when a key is not present in the tree.
+	ErrNotFound = errors.New("bplustree: key not found")
+
+	// ErrTxReadOnly is returned when writing through a transaction
+	// started with View.
+	ErrTxReadOnly = errors.New("bplustree: transaction is read-only")
+
+	// ErrTxDone is returned when writing through a transaction after the
+	// function it was passed to has returned; reading through one panics
+	// with it.
+	ErrTxDone = errors.New("bplustree: transaction has finished")
+
+	// ErrConflict is returned by Update when a key or range read by the
+	// transaction was modified after the transaction began. Nothing was
+	// written; the transaction may be retried.
+	ErrConflict = errors.New("bplustree: transaction conflict")
+
+	// ErrInvalidFile is returned when a file opened as a DiskTree is not
+	// a tree file, was written with different codecs, or is damaged.
+	ErrInvalidFile = errors.New("bplustree: invalid tree file")
+
+	// ErrClosed is returned when using a DiskTree after Close.
+	ErrClosed = errors.New("bplustree: tree is closed")
+
+	// ErrReadOnly is returned when modifying a DiskTree opened with
+	// WithReadOnly.
+	ErrReadOnly = errors.New("bplustree: tree is read-only")
+
+	// ErrNeedsRecovery is returned when opening a DiskTree read-only
+	// while its write-ahead log holds modifications not yet written to
+	// the file. Opening the tree for writing once recovers it.
+	ErrNeedsRecovery = errors.New("bplustree: tree needs recovery")
+)
+
+// ErrCorruptPage is returned when a page of a DiskTree file is damaged:
+// its contents do not match their checksum, or make no sense for the
+// node it should hold. It wraps ErrInvalidFile.
+type ErrCorruptPage struct {
+	PageID uint64 // the page, 0 for the meta page
+}
+
+func (e ErrCorruptPage) Error() string {
+	return fmt.Sprintf("bplustree: page %d is corrupt", e.PageID)
+}
+
+func (e ErrCorruptPage) Unwrap() error {
+	return ErrInvalidFile
+}
@@ -0,0 +1,3 @@
+module github.com/cagnosolutions/bplustree
+
+go 1.23
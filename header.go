@@ -1,62 +1,97 @@
 package bplustree
 
+import "sync"
+
 const MIN_ORDER = 3
-const MAX_ORDER = 64
-const MAX_ENTRIES = 64
-const MAX_LEVEL = 10
 
-type bplus_node interface {
+// DEFAULT_ORDER and DEFAULT_ENTRIES trade insert cost against lookup
+// cost. BenchmarkPut and BenchmarkGet time random int64 and 21 byte
+// string keys at fan-outs from 16 to 1024: inserts are fastest from 32
+// to 128 and slow down beyond as wider nodes cost more to split, while
+// lookups keep getting faster as the tree gets shallower.
+const DEFAULT_ORDER = 64
+const DEFAULT_ENTRIES = 64
+
+type bplus_node[K, V any] interface {
 	getKind() int
-	getParent() *bplus_non_leaf
+	getParent() *bplus_non_leaf[K, V]
+	setParent(parent *bplus_non_leaf[K, V])
+	getLatch() *sync.RWMutex
 }
 
-type bplus_non_leaf struct {
+type bplus_non_leaf[K, V any] struct {
+	latch    sync.RWMutex
 	kind     int
-	parent   *bplus_non_leaf
-	next     *bplus_non_leaf
+	gen      uint64
+	parent   *bplus_non_leaf[K, V]
+	next     *bplus_non_leaf[K, V]
 	children int
-	key      [MAX_ORDER - 1]int
-	sub_ptr  [MAX_ORDER]*bplus_node
+	key      []K
+	sub_ptr  []bplus_node[K, V]
 }
 
-func (nln *bplus_non_leaf) getKind() int {
+func (nln *bplus_non_leaf[K, V]) getKind() int {
 	return nln.kind
 }
 
-func (nln *bplus_non_leaf) getParent() *bplus_non_leaf {
+func (nln *bplus_non_leaf[K, V]) getParent() *bplus_non_leaf[K, V] {
 	return nln.parent
 }
 
-type bplus_leaf struct {
+func (nln *bplus_non_leaf[K, V]) setParent(parent *bplus_non_leaf[K, V]) {
+	nln.parent = parent
+}
+
+func (nln *bplus_non_leaf[K, V]) getLatch() *sync.RWMutex {
+	return &nln.latch
+}
+
+type bplus_leaf[K, V any] struct {
+	latch   sync.RWMutex
 	kind    int
-	parent  *bplus_non_leaf
-	next    *bplus_leaf
+	gen     uint64
+	parent  *bplus_non_leaf[K, V]
+	prev    *bplus_leaf[K, V]
+	next    *bplus_leaf[K, V]
 	entries int
-	key     [MAX_ENTRIES]int
-	data    [MAX_ENTRIES]int
+	key     []K
+	data    []V
 }
 
-func (ln *bplus_leaf) getKind() int {
+func (ln *bplus_leaf[K, V]) getKind() int {
 	return ln.kind
 }
 
-func (nln *bplus_leaf) getParent() *bplus_non_leaf {
+func (ln *bplus_leaf[K, V]) getParent() *bplus_non_leaf[K, V] {
 	return ln.parent
 }
 
-type bplus_tree struct {
-	order   int
-	entries int
-	level   int
-	root    *bplus_node
-	head    [MAX_LEVEL]*bplus_node
-}
-
-type btree interface {
-	bplus_tree_dump(tree *bplus_tree)
-	bplus_tree_get(tree *bplus_tree, key int) int
-	bplus_tree_put(tree *bplus_tree, key, data int) int
-	bplus_tree_get_range(tree *bplus_tree, key1, key2 int) int
-	bplus_tree_init(level, order, entries int) *bplus_tree
-	bplus_tree_deinit(tree *bplus_tree)
+func (ln *bplus_leaf[K, V]) setParent(parent *bplus_non_leaf[K, V]) {
+	ln.parent = parent
+}
+
+func (ln *bplus_leaf[K, V]) getLatch() *sync.RWMutex {
+	return &ln.latch
+}
+
+type bplus_tree[K, V any] struct {
+	latch       sync.RWMutex /* guards root and head in crabbing mode */
+	crabbing    bool
+	cow_mu      sync.RWMutex    /* held exclusively by a crabbing writer copying nodes */
+	cow_latched []*sync.RWMutex /* latches of the copies it made */
+	gen         uint64          /* nodes of older generations are shared with snapshots */
+	order       int
+	entries     int
+	root        bplus_node[K, V]
+	head        []bplus_node[K, V]
+	compare     func(a, b K) int
+}
+
+type btree[K, V any] interface {
+	bplus_tree_dump(tree *bplus_tree[K, V])
+	bplus_tree_get(tree *bplus_tree[K, V], key K) (V, bool)
+	bplus_tree_put(tree *bplus_tree[K, V], key K, data V) (V, bool, error)
+	bplus_tree_get_range(tree *bplus_tree[K, V], min, max K, flags int, fn func(key K, data V) bool)
+	bplus_tree_get_range_reverse(tree *bplus_tree[K, V], max, min K, flags int, fn func(key K, data V) bool)
+	bplus_tree_init(order, entries int, compare func(a, b K) int) *bplus_tree[K, V]
 }
@@ -0,0 +1,288 @@
+package bplustree
+
+import "sync"
+
+/*
+ * Latch crabbing
+ *
+ * In crabbing mode every node carries its own reader/writer latch and
+ * tree.latch guards tree.root and tree.head. Readers couple latches on
+ * the way down: a child is latched before its parent is released.
+ * Writers latch exclusively from the top and let go of every ancestor
+ * as soon as they reach a node that is safe, one that cannot split on
+ * insertion or underflow on removal, so writers working on different
+ * parts of the tree only meet briefly near the root.
+ *
+ * The insertion and removal code runs unchanged on the latched path.
+ * Siblings it borrows from or merges with are latched while their
+ * parent is held, and the neighbor whose prev link it updates is
+ * latched left to right. Scans walking the leaf chain never wait for a
+ * latch while holding one: if the next leaf is busy they let go and
+ * descend again from the root.
+ *
+ * Nodes shared with a snapshot must be copied before they are changed,
+ * and a copy has to be linked into its parent and its leaf neighbors,
+ * which a crabbing writer may no longer hold. Writers therefore share
+ * tree.cow_mu and, if the nodes they would change are shared, start
+ * over holding it exclusively, latching the whole path and every copy
+ * they make. Readers are unaffected.
+ *
+ * When crabbing is off all of the helpers below reduce to the plain
+ * unlatched code.
+ */
+
+const (
+	CRAB_INSERT = iota
+	CRAB_DELETE
+	CRAB_UPDATE
+	CRAB_COPY
+)
+
+/* exclusive latches held by a writer, from the top of the tree down */
+type crab_path struct {
+	cow       bool /* tree.cow_mu held shared */
+	exclusive bool /* tree.cow_mu held exclusively */
+	root      bool
+	latches   []*sync.RWMutex
+	buf       [8]*sync.RWMutex
+}
+
+/* release everything a writer holds once it is done */
+func crab_release[K, V any](tree *bplus_tree[K, V], path *crab_path) {
+	path.release(&tree.latch)
+	if path.exclusive {
+		for i, latch := range tree.cow_latched {
+			latch.Unlock()
+			tree.cow_latched[i] = nil
+		}
+		tree.cow_latched = tree.cow_latched[:0]
+		tree.cow_mu.Unlock()
+		path.exclusive = false
+	}
+	if path.cow {
+		tree.cow_mu.RUnlock()
+		path.cow = false
+	}
+}
+
+/* latch a copy made by a writer holding tree.cow_mu exclusively */
+func latch_copy[K, V any](tree *bplus_tree[K, V], latch *sync.RWMutex) {
+	if tree.crabbing {
+		latch.Lock()
+		tree.cow_latched = append(tree.cow_latched, latch)
+	}
+}
+
+func (path *crab_path) release(tree_latch *sync.RWMutex) {
+	if path.root {
+		tree_latch.Unlock()
+		path.root = false
+	}
+	for i, latch := range path.latches {
+		latch.Unlock()
+		path.latches[i] = nil
+	}
+	path.latches = path.latches[:0]
+}
+
+func latch_lock[K, V any](tree *bplus_tree[K, V], latch *sync.RWMutex) {
+	if tree.crabbing {
+		latch.Lock()
+	}
+}
+
+func latch_unlock[K, V any](tree *bplus_tree[K, V], latch *sync.RWMutex) {
+	if tree.crabbing {
+		latch.Unlock()
+	}
+}
+
+func latch_runlock[K, V any](tree *bplus_tree[K, V], latch *sync.RWMutex) {
+	if tree.crabbing {
+		latch.RUnlock()
+	}
+}
+
+func latch_try_rlock[K, V any](tree *bplus_tree[K, V], latch *sync.RWMutex) bool {
+	if tree.crabbing {
+		return latch.TryRLock()
+	}
+	return true
+}
+
+/* a node is safe if op cannot change its parent or the root */
+func node_is_safe[K, V any](tree *bplus_tree[K, V], node bplus_node[K, V], op int) bool {
+	switch op {
+	case CRAB_INSERT:
+		switch n := node.(type) {
+		case *bplus_non_leaf[K, V]:
+			return n.children < tree.order
+		case *bplus_leaf[K, V]:
+			return n.entries < tree.entries
+		}
+	case CRAB_COPY:
+		return false
+	case CRAB_DELETE:
+		switch n := node.(type) {
+		case *bplus_non_leaf[K, V]:
+			if n.parent == nil {
+				return n.children > 2
+			}
+			return n.children > (tree.order+1)/2
+		case *bplus_leaf[K, V]:
+			if n.parent == nil {
+				return n.entries > 1
+			}
+			return n.entries > (tree.entries+1)/2
+		}
+	}
+	return true
+}
+
+/* locate the leaf for key, latched exclusively along with every unsafe ancestor */
+func bplus_tree_locate_exclusive[K, V any](tree *bplus_tree[K, V], key K, op int, path *crab_path) *bplus_leaf[K, V] {
+
+	if !tree.crabbing {
+		return bplus_tree_locate(tree, key)
+	}
+
+	tree.cow_mu.RLock()
+	path.cow = true
+	ln, shared := bplus_tree_crab(tree, key, op, path)
+	if shared {
+		/* start over alone, holding everything the copies will touch */
+		crab_release(tree, path)
+		tree.cow_mu.Lock()
+		path.exclusive = true
+		ln, _ = bplus_tree_crab(tree, key, CRAB_COPY, path)
+	}
+	return ln
+}
+
+/*
+ * Latch the path down to the leaf for key, reporting whether a node the
+ * operation may change is shared with a snapshot. Since copying a node
+ * copies its ancestors too, only the leaf and, for a removal, the
+ * siblings a rebalance may borrow from need checking.
+ */
+func bplus_tree_crab[K, V any](tree *bplus_tree[K, V], key K, op int, path *crab_path) (*bplus_leaf[K, V], bool) {
+
+	var shared bool
+
+	path.latches = path.buf[:0]
+	tree.latch.Lock()
+	path.root = true
+
+	var node bplus_node[K, V] = tree.root
+	var parent *bplus_non_leaf[K, V]
+	var i int
+
+	for node != nil {
+		latch := node.getLatch()
+		latch.Lock()
+		if node_is_safe(tree, node, op) {
+			path.release(&tree.latch)
+		} else if op == CRAB_DELETE && parent != nil {
+			if i > 0 && node_gen(parent.sub_ptr[i-1]) != tree.gen {
+				shared = true
+			}
+			if i < parent.children-1 && node_gen(parent.sub_ptr[i+1]) != tree.gen {
+				shared = true
+			}
+		}
+		path.latches = append(path.latches, latch)
+		switch node.getKind() {
+		case BPLUS_TREE_NON_LEAF:
+			parent = node.(*bplus_non_leaf[K, V])
+			i = non_leaf_index(tree, parent, key)
+			node = parent.sub_ptr[i]
+		case BPLUS_TREE_LEAF:
+			ln := node.(*bplus_leaf[K, V])
+			return ln, shared || ln.gen != tree.gen
+		default:
+			assert(false, 136)
+		}
+	}
+	/* empty tree, the root latch is still held */
+	return nil, false
+}
+
+/* locate the leaf for key, latched shared */
+func bplus_tree_locate_shared[K, V any](tree *bplus_tree[K, V], key K) *bplus_leaf[K, V] {
+
+	if !tree.crabbing {
+		return bplus_tree_locate(tree, key)
+	}
+
+	tree.latch.RLock()
+	var node bplus_node[K, V] = tree.root
+	if node == nil {
+		tree.latch.RUnlock()
+		return nil
+	}
+	node.getLatch().RLock()
+	tree.latch.RUnlock()
+
+	for {
+		switch node.getKind() {
+		case BPLUS_TREE_NON_LEAF:
+			child := non_leaf_locate(tree, node.(*bplus_non_leaf[K, V]), key)
+			child.getLatch().RLock()
+			node.getLatch().RUnlock()
+			node = child
+		case BPLUS_TREE_LEAF:
+			return node.(*bplus_leaf[K, V])
+		default:
+			assert(false, 167)
+		}
+	}
+}
+
+/* the leftmost leaf, latched shared */
+func bplus_tree_first_leaf_shared[K, V any](tree *bplus_tree[K, V]) *bplus_leaf[K, V] {
+
+	if !tree.crabbing {
+		return bplus_tree_first_leaf(tree)
+	}
+
+	tree.latch.RLock()
+	defer tree.latch.RUnlock()
+	var ln *bplus_leaf[K, V] = bplus_tree_first_leaf(tree)
+	if ln != nil {
+		/* the leftmost leaf is never merged away, and only copied under the root latch */
+		ln.latch.RLock()
+	}
+	return ln
+}
+
+/* the rightmost leaf, latched shared */
+func bplus_tree_last_leaf_shared[K, V any](tree *bplus_tree[K, V]) *bplus_leaf[K, V] {
+
+	if !tree.crabbing {
+		return bplus_tree_last_leaf(tree)
+	}
+
+	tree.latch.RLock()
+	var node bplus_node[K, V] = tree.root
+	if node == nil {
+		tree.latch.RUnlock()
+		return nil
+	}
+	node.getLatch().RLock()
+	tree.latch.RUnlock()
+
+	for {
+		switch node.getKind() {
+		case BPLUS_TREE_NON_LEAF:
+			nln := node.(*bplus_non_leaf[K, V])
+			child := nln.sub_ptr[nln.children-1]
+			child.getLatch().RLock()
+			nln.latch.RUnlock()
+			node = child
+		case BPLUS_TREE_LEAF:
+			return node.(*bplus_leaf[K, V])
+		default:
+			assert(false, 216)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package bplustree
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+/* walk the leaf chain from the head, checking it against the leaves reached from the root */
+func leaf_chain_check[K, V any](t *testing.T, tree *bplus_tree[K, V]) int {
+	t.Helper()
+
+	var leaves []*bplus_leaf[K, V]
+	var walk func(node bplus_node[K, V])
+	walk = func(node bplus_node[K, V]) {
+		switch node := node.(type) {
+		case *bplus_leaf[K, V]:
+			leaves = append(leaves, node)
+		case *bplus_non_leaf[K, V]:
+			for i := 0; i < node.children; i++ {
+				walk(node.sub_ptr[i])
+			}
+		}
+	}
+	if tree.root != nil {
+		walk(tree.root)
+	}
+
+	var prev *bplus_leaf[K, V]
+	n, count := 0, 0
+	for leaf := bplus_tree_first_leaf(tree); leaf != nil; leaf = leaf.next {
+		if n >= len(leaves) || leaves[n] != leaf {
+			t.Fatalf("leaf %d of the chain is not leaf %d under the root", n, n)
+		}
+		if leaf.prev != prev {
+			t.Fatalf("leaf %d: prev does not point back at leaf %d", n, n-1)
+		}
+		for i := 0; i < leaf.entries; i++ {
+			if i > 0 && tree.compare(leaf.key[i-1], leaf.key[i]) >= 0 {
+				t.Fatalf("leaf %d: key %v is not above %v", n, leaf.key[i], leaf.key[i-1])
+			}
+		}
+		if prev != nil && leaf.entries > 0 && prev.entries > 0 &&
+			tree.compare(prev.key[prev.entries-1], leaf.key[0]) >= 0 {
+			t.Fatalf("leaf %d: first key %v is not above the last of leaf %d", n, leaf.key[0], n-1)
+		}
+		count += leaf.entries
+		prev = leaf
+		n++
+	}
+	if n != len(leaves) {
+		t.Fatalf("the chain holds %d leaves, the root reaches %d", n, len(leaves))
+	}
+	return count
+}
+
+func TestLatchCrabbingStress(t *testing.T) {
+	const writers = 6
+	const keys = 2000
+	const ops = 8000
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+
+	tree := New[int, int](WithOrder(4), WithEntries(3), WithLatchCrabbing())
+
+	/* writer g owns the keys congruent to g, so its model is exact */
+	models := make([]map[int]int, writers)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for g := 0; g < writers; g++ {
+		models[g] = make(map[int]int)
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			model := models[g]
+			r := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < ops; i++ {
+				key := r.Intn(keys)*writers + g
+				switch r.Intn(6) {
+				case 0, 1, 2:
+					tree.Put(key, i)
+					model[key] = i
+				case 3, 4:
+					value, ok := tree.Delete(key)
+					want, found := model[key]
+					if ok != found || value != want {
+						t.Errorf("Delete(%d) = %d, %v, want %d, %v", key, value, ok, want, found)
+					}
+					delete(model, key)
+				case 5:
+					value, ok := tree.Get(key)
+					want, found := model[key]
+					if ok != found || value != want {
+						t.Errorf("Get(%d) = %d, %v, want %d, %v", key, value, ok, want, found)
+					}
+				}
+			}
+		}(g)
+	}
+
+	/* readers scan, step cursors and snapshot while the writers split and merge */
+	var readers sync.WaitGroup
+	for g := 0; g < 3; g++ {
+		readers.Add(1)
+		go func(g int) {
+			defer readers.Done()
+			r := rand.New(rand.NewSource(int64(100 + g)))
+			cursor := tree.Cursor()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				lo := r.Intn(keys * writers)
+				switch g {
+				case 0:
+					prev := -1
+					tree.AscendRange(lo, lo+500, func(key, _ int) bool {
+						if key <= prev || key < lo || key > lo+500 {
+							t.Errorf("AscendRange(%d, %d) gave %d after %d", lo, lo+500, key, prev)
+						}
+						prev = key
+						return true
+					})
+				case 1:
+					prev := -1
+					for ok := cursor.Seek(lo); ok && cursor.Key() < lo+200; ok = cursor.Next() {
+						if cursor.Key() <= prev {
+							t.Errorf("cursor gave %d after %d", cursor.Key(), prev)
+						}
+						prev = cursor.Key()
+					}
+					prev = 1 << 40
+					for ok := cursor.Seek(lo); ok && cursor.Key() > lo-200; ok = cursor.Prev() {
+						if cursor.Key() >= prev {
+							t.Errorf("cursor gave %d before %d", cursor.Key(), prev)
+						}
+						prev = cursor.Key()
+					}
+				case 2:
+					snap := tree.Snapshot()
+					prev, n := -1, 0
+					snap.Ascend(func(key, _ int) bool {
+						if key <= prev {
+							t.Errorf("snapshot gave %d after %d", key, prev)
+						}
+						prev = key
+						n++
+						return true
+					})
+					if n != snap.Len() {
+						t.Errorf("snapshot holds %d keys, Len says %d", n, snap.Len())
+					}
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	if t.Failed() {
+		return
+	}
+
+	total := 0
+	for _, model := range models {
+		total += len(model)
+		for key, want := range model {
+			if value, ok := tree.Get(key); !ok || value != want {
+				t.Fatalf("Get(%d) = %d, %v, want %d", key, value, ok, want)
+			}
+		}
+	}
+	if n := leaf_chain_check(t, tree.tree); n != total {
+		t.Fatalf("the leaves hold %d keys, the writers left %d", n, total)
+	}
+	if tree.Len() != total {
+		t.Fatalf("Len() = %d, the writers left %d", tree.Len(), total)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package bplustree
+
+import (
+	"errors"
+	"os"
+)
+
+/* files cannot be mapped here, read-only trees read pages through the cache */
+func mmap_file(file *os.File, size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap_file(data []byte) error {
+	return nil
+}
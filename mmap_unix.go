@@ -0,0 +1,17 @@
+//go:build unix
+
+package bplustree
+
+import (
+	"os"
+	"syscall"
+)
+
+/* map the first size bytes of file for reading, shared with every other process mapping it */
+func mmap_file(file *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap_file(data []byte) error {
+	return syscall.Munmap(data)
+}
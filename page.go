@@ -0,0 +1,325 @@
+package bplustree
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"slices"
+)
+
+/*
+ * Disk pages
+ *
+ * A tree file is an array of PAGE_SIZE pages addressed by page id, the
+ * index of the page in the file. Page 0 is the meta page describing the
+ * tree and every other page holds one node, so page id 0 doubles as the
+ * nil link.
+ *
+ * A node page starts with a header
+ *
+ *	kind     1 byte   BPLUS_TREE_LEAF, BPLUS_TREE_NON_LEAF or PAGE_FREE
+ *	         3 bytes  unused
+ *	count    4 bytes  entries of a leaf, children of a non-leaf
+ *	parent   8 bytes
+ *	prev     8 bytes  leaves only
+ *	next     8 bytes
+ *	crc      4 bytes  CRC-32C of the page, this field taken as zero
+ *	         4 bytes  unused
+ *
+ * followed by its slots. A leaf has room for tree.entries keys followed
+ * by as many values, a non-leaf for tree.order-1 keys followed by
+ * tree.order child page ids. Keys and values are written by the tree's
+ * codecs and every integer is little-endian.
+ *
+ * Pages of merged nodes are kept on a free list, linked through the next
+ * field of their header and otherwise zeroed, and reused before the file
+ * grows. Compact moves nodes into the free pages and cuts the file short.
+ *
+ * A page is sealed with its checksum when it is logged, before it can
+ * reach the file, and checked whenever it is read from the file, so a
+ * page the disk damaged is reported as an ErrCorruptPage rather than
+ * taken apart.
+ *
+ * The meta page holds two copies of the meta, the first at offset 0 and
+ * the second at META_SLOT, in different sectors. Writing the meta
+ * overwrites the older copy, so a crash tearing that write leaves the
+ * other whole, and the log still holds every record after it. The
+ * intact copy with the later lsn is the tree's. Each copy holds
+ *
+ *	magic    8 bytes  "bplustre"
+ *	version  4 bytes
+ *	page     4 bytes  PAGE_SIZE
+ *	ksize    4 bytes  encoded key size
+ *	vsize    4 bytes  encoded value size
+ *	order    4 bytes
+ *	entries  4 bytes
+ *	root     8 bytes  0 for an empty tree
+ *	height   4 bytes  0 for an empty tree, 1 if the root is a leaf
+ *	         4 bytes  unused
+ *	count    8 bytes  number of keys
+ *	npages   8 bytes  pages in the file, the meta page included
+ *	lsn      8 bytes  last log record the file holds, see wal.go
+ *	free     8 bytes  first page of the free list, 0 if it is empty
+ *	nfree    8 bytes  pages on the free list
+ *	crc      4 bytes  CRC-32C of the copy, this field taken as zero
+ */
+
+const PAGE_SIZE = 4096
+
+const (
+	PAGE_HEADER  = 40
+	PAGE_FREE    = 2
+	META_VERSION = 3
+	META_SLOT    = PAGE_SIZE / 2
+)
+
+const (
+	page_crc  = 32 /* offset of the checksum in a node page */
+	meta_crc  = 88 /* and in a meta copy */
+	meta_size = meta_crc + 4
+)
+
+const disk_magic = "bplustre"
+
+type pgid uint64
+
+type disk_page struct {
+	id    pgid
+	buf   []byte
+	dirty bool
+	pins  int    /* users of the page, see pool.go */
+	ref   bool   /* fetched since the clock hand last passed */
+	lsn   uint64 /* log record that last changed the page, see wal.go */
+}
+
+func page_kind(p *disk_page) int {
+	return int(p.buf[0])
+}
+
+func page_set_kind(p *disk_page, kind int) {
+	p.buf[0] = byte(kind)
+	p.dirty = true
+}
+
+func page_count(p *disk_page) int {
+	return int(binary.LittleEndian.Uint32(p.buf[4:]))
+}
+
+func page_set_count(p *disk_page, count int) {
+	binary.LittleEndian.PutUint32(p.buf[4:], uint32(count))
+	p.dirty = true
+}
+
+func page_parent(p *disk_page) pgid {
+	return pgid(binary.LittleEndian.Uint64(p.buf[8:]))
+}
+
+func page_set_parent(p *disk_page, parent pgid) {
+	binary.LittleEndian.PutUint64(p.buf[8:], uint64(parent))
+	p.dirty = true
+}
+
+func page_prev(p *disk_page) pgid {
+	return pgid(binary.LittleEndian.Uint64(p.buf[16:]))
+}
+
+func page_set_prev(p *disk_page, prev pgid) {
+	binary.LittleEndian.PutUint64(p.buf[16:], uint64(prev))
+	p.dirty = true
+}
+
+func page_next(p *disk_page) pgid {
+	return pgid(binary.LittleEndian.Uint64(p.buf[24:]))
+}
+
+func page_set_next(p *disk_page, next pgid) {
+	binary.LittleEndian.PutUint64(p.buf[24:], uint64(next))
+	p.dirty = true
+}
+
+/* the checksum of buf with the 4 bytes at off taken as zero */
+func page_checksum(buf []byte, off int) uint32 {
+	var zero [4]byte
+	crc := crc32.Update(0, crc_table, buf[:off])
+	crc = crc32.Update(crc, crc_table, zero[:])
+	return crc32.Update(crc, crc_table, buf[off+4:])
+}
+
+/* store the checksum of a node page about to be written */
+func page_seal(p *disk_page) {
+	binary.LittleEndian.PutUint32(p.buf[page_crc:], page_checksum(p.buf, page_crc))
+}
+
+/* check a node page read from the file against its checksum */
+func page_verify(p *disk_page) error {
+	if binary.LittleEndian.Uint32(p.buf[page_crc:]) != page_checksum(p.buf, page_crc) {
+		return ErrCorruptPage{PageID: uint64(p.id)}
+	}
+	return nil
+}
+
+/* the fields of the meta page that change as the tree does */
+type disk_meta struct {
+	root   pgid
+	height int
+	count  uint64
+	npages uint64
+	free   pgid
+	nfree  uint64
+}
+
+/* a page pinned by the modification in progress */
+type pager_pin struct {
+	page  *disk_page
+	orig  []byte /* contents before the modification, nil for a new page */
+	dirty bool
+}
+
+/* reads and writes the pages of a tree file through its buffer pool */
+type pager struct {
+	file   disk_file
+	pool   buffer_pool
+	pages  map[pgid]*pager_pin
+	mapped []byte /* the whole file when opened read-only and mapped, see mmap_unix.go */
+}
+
+/* page id, pinned until pager_unpin */
+func pager_read(p *pager, meta *disk_meta, id pgid) (*disk_page, error) {
+	if id == 0 || uint64(id) >= meta.npages {
+		return nil, fmt.Errorf("%w: page %d out of range", ErrInvalidFile, id)
+	}
+	if p.mapped != nil {
+		/* no copy, no cache, nothing to pin */
+		off := int(id) * PAGE_SIZE
+		page := &disk_page{id: id, buf: p.mapped[off : off+PAGE_SIZE : off+PAGE_SIZE]}
+		if err := page_verify(page); err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+	return pool_fetch(&p.pool, id)
+}
+
+func pager_unpin(p *pager, page *disk_page) {
+	if p.mapped != nil {
+		return
+	}
+	pool_unpin(&p.pool, page)
+}
+
+/* page id for modification, pinned and shared by every use until commit or abort */
+func pager_get(p *pager, meta *disk_meta, id pgid) (*disk_page, error) {
+	if pin, ok := p.pages[id]; ok {
+		return pin.page, nil
+	}
+	page, err := pager_read(p, meta, id)
+	if err != nil {
+		return nil, err
+	}
+	p.pages[id] = &pager_pin{page: page, orig: bytes.Clone(page.buf), dirty: page.dirty}
+	return page, nil
+}
+
+/* a new zeroed page, off the free list if it has one, else at the end of the file */
+func pager_alloc(p *pager, meta *disk_meta) (*disk_page, error) {
+	if meta.free != 0 {
+		page, err := pager_get(p, meta, meta.free)
+		if err != nil {
+			return nil, err
+		}
+		if page_kind(page) != PAGE_FREE || meta.nfree == 0 {
+			return nil, fmt.Errorf("%w: page %d on the free list is in use", ErrInvalidFile, page.id)
+		}
+		meta.free = page_next(page)
+		meta.nfree--
+		clear(page.buf)
+		page.dirty = true
+		return page, nil
+	}
+	page, err := pool_alloc(&p.pool, pgid(meta.npages))
+	if err != nil {
+		return nil, err
+	}
+	meta.npages++
+	p.pages[page.id] = &pager_pin{page: page}
+	return page, nil
+}
+
+/*
+ * Page id for modification, zeroed whatever it held, for a page no node
+ * or free list uses. Such a page may never have been written, or been
+ * cut short by a crash, so it is not checked.
+ */
+func pager_claim(p *pager, meta *disk_meta, id pgid) (*disk_page, error) {
+	page, err := pager_get(p, meta, id)
+	if _, ok := err.(ErrCorruptPage); ok {
+		if page, err = pool_alloc(&p.pool, id); err == nil {
+			p.pages[id] = &pager_pin{page: page}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	clear(page.buf)
+	page.dirty = true
+	return page, nil
+}
+
+/* put page, pinned for modification, on the free list */
+func pager_free(p *pager, meta *disk_meta, page *disk_page) {
+	clear(page.buf)
+	page_set_kind(page, PAGE_FREE)
+	page_set_next(page, meta.free)
+	meta.free = page.id
+	meta.nfree++
+}
+
+/* the pages changed by the modification in progress, in page order */
+func pager_changed(p *pager) []*disk_page {
+	var pages []*disk_page
+	for _, pin := range p.pages {
+		if pin.orig == nil || !bytes.Equal(pin.orig, pin.page.buf) {
+			pages = append(pages, pin.page)
+		}
+	}
+	slices.SortFunc(pages, func(a, b *disk_page) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return pages
+}
+
+/* keep the changes of a modification, they reach the file when flushed */
+func pager_commit(p *pager) {
+	for id, pin := range p.pages {
+		pager_unpin(p, pin.page)
+		delete(p.pages, id)
+	}
+}
+
+/* undo the changes of a failed modification */
+func pager_abort(p *pager) {
+	for id, pin := range p.pages {
+		if pin.orig == nil {
+			pool_discard(&p.pool, pin.page)
+		} else {
+			copy(pin.page.buf, pin.orig)
+			pin.page.dirty = pin.dirty
+			pager_unpin(p, pin.page)
+		}
+		delete(p.pages, id)
+	}
+}
+
+/* write every changed page back, then the meta copy at off once they are on disk */
+func pager_flush(p *pager, meta []byte, off int64) error {
+	if err := pool_flush(&p.pool); err != nil {
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	_, err := p.file.WriteAt(meta, off)
+	return err
+}
@@ -0,0 +1,228 @@
+package bplustree
+
+import "sync"
+
+/*
+ * Buffer pool
+ *
+ * Pages of a tree file are cached in a fixed number of frames. A page is
+ * pinned while it is in use and only unpinned frames are reused. The
+ * victim is chosen by the CLOCK algorithm: each frame has a reference
+ * bit, set whenever the page is fetched, and the hand sweeps the frames
+ * clearing bits until it finds an unpinned frame whose bit is clear, so
+ * pages in frequent use, such as the nodes near the root, stay resident.
+ *
+ * Changed pages are marked dirty and written back when their frame is
+ * reused or the pool is flushed, after forcing the log up to the record
+ * that last changed them, see wal.go. If every frame is pinned the pool
+ * grows past its size, and gives the extra frames up again on later
+ * misses.
+ */
+
+const DEFAULT_CACHE_SIZE = 4 << 20
+
+const MIN_CACHE_PAGES = 16
+
+type buffer_pool struct {
+	mu        sync.Mutex
+	file      disk_file
+	wal       *write_ahead_log
+	frames    []*disk_page /* the clock */
+	table     map[pgid]*disk_page
+	hand      int
+	size      int /* frames to keep */
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func pool_init(pool *buffer_pool, file disk_file, wal *write_ahead_log, bytes int) {
+	pool.file = file
+	pool.wal = wal
+	pool.size = max(bytes/PAGE_SIZE, MIN_CACHE_PAGES)
+	pool.table = make(map[pgid]*disk_page)
+}
+
+/* remove the frame under the hand from the clock */
+func pool_drop(pool *buffer_pool) {
+	last := len(pool.frames) - 1
+	pool.frames[pool.hand] = pool.frames[last]
+	pool.frames[last] = nil
+	pool.frames = pool.frames[:last]
+	if pool.hand == last {
+		pool.hand = 0
+	}
+}
+
+/* evict unpinned pages until there is room for one more, writing back dirty ones */
+func pool_make_room(pool *buffer_pool) error {
+
+	for len(pool.frames) >= pool.size {
+		var victim *disk_page
+		/* two sweeps clear every reference bit, a third finds nothing new */
+		for n := 0; n < 2*len(pool.frames) && victim == nil; n++ {
+			page := pool.frames[pool.hand]
+			if page.pins > 0 {
+				pool.hand = (pool.hand + 1) % len(pool.frames)
+			} else if page.ref {
+				page.ref = false
+				pool.hand = (pool.hand + 1) % len(pool.frames)
+			} else {
+				victim = page
+			}
+		}
+		if victim == nil {
+			/* everything is pinned, grow */
+			return nil
+		}
+		if victim.dirty {
+			if err := pool_write(pool, victim); err != nil {
+				return err
+			}
+		}
+		delete(pool.table, victim.id)
+		pool_drop(pool)
+		pool.evictions++
+	}
+	return nil
+}
+
+/* write a dirty page back, its log record first */
+func pool_write(pool *buffer_pool, page *disk_page) error {
+	if err := wal_sync_to(pool.wal, page.lsn); err != nil {
+		return err
+	}
+	if _, err := pool.file.WriteAt(page.buf, int64(page.id)*PAGE_SIZE); err != nil {
+		return err
+	}
+	page.dirty = false
+	return nil
+}
+
+/* page id, pinned */
+func pool_fetch(pool *buffer_pool, id pgid) (*disk_page, error) {
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if page, ok := pool.table[id]; ok {
+		page.pins++
+		page.ref = true
+		pool.hits++
+		return page, nil
+	}
+	pool.misses++
+	if err := pool_make_room(pool); err != nil {
+		return nil, err
+	}
+	page := &disk_page{id: id, buf: make([]byte, PAGE_SIZE), pins: 1, ref: true}
+	if _, err := pool.file.ReadAt(page.buf, int64(id)*PAGE_SIZE); err != nil {
+		return nil, err
+	}
+	if err := page_verify(page); err != nil {
+		return nil, err
+	}
+	pool.table[id] = page
+	pool.frames = append(pool.frames, page)
+	return page, nil
+}
+
+/* a new zeroed page id, pinned and dirty */
+func pool_alloc(pool *buffer_pool, id pgid) (*disk_page, error) {
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if err := pool_make_room(pool); err != nil {
+		return nil, err
+	}
+	page := &disk_page{id: id, buf: make([]byte, PAGE_SIZE), dirty: true, pins: 1, ref: true}
+	pool.table[id] = page
+	pool.frames = append(pool.frames, page)
+	return page, nil
+}
+
+func pool_unpin(pool *buffer_pool, page *disk_page) {
+	pool.mu.Lock()
+	page.pins--
+	pool.mu.Unlock()
+}
+
+/* drop a pinned page without writing it back */
+func pool_discard(pool *buffer_pool, page *disk_page) {
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	delete(pool.table, page.id)
+	for i := range pool.frames {
+		if pool.frames[i] == page {
+			pool.hand = i
+			pool_drop(pool)
+			return
+		}
+	}
+}
+
+/* drop every cached page at or past end, where the file is about to be cut */
+func pool_truncate(pool *buffer_pool, end pgid) {
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for i := 0; i < len(pool.frames); {
+		if page := pool.frames[i]; page.id >= end {
+			delete(pool.table, page.id)
+			pool.hand = i
+			pool_drop(pool)
+		} else {
+			i++
+		}
+	}
+	pool.hand = 0
+}
+
+/* write back every dirty page */
+func pool_flush(pool *buffer_pool) error {
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, page := range pool.frames {
+		if !page.dirty {
+			continue
+		}
+		if err := pool_write(pool, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CacheStats describes the activity of a DiskTree's page cache.
+type CacheStats struct {
+	Hits      uint64 // page fetches served from memory
+	Misses    uint64 // page fetches that read the file
+	Evictions uint64 // pages dropped to make room
+	Pages     int    // pages currently cached
+	Dirty     int    // cached pages not yet written to the file
+}
+
+func pool_stats(pool *buffer_pool) CacheStats {
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	stats := CacheStats{
+		Hits:      pool.hits,
+		Misses:    pool.misses,
+		Evictions: pool.evictions,
+		Pages:     len(pool.frames),
+	}
+	for _, page := range pool.frames {
+		if page.dirty {
+			stats.Dirty++
+		}
+	}
+	return stats
+}
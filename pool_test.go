@@ -0,0 +1,168 @@
+package bplustree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * a pool of MIN_CACHE_PAGES frames over a fresh file of n sealed pages,
+ * page i holding byte i, and a log that is only forced on demand
+ */
+func pool_test_open(t *testing.T, n int) *buffer_pool {
+	t.Helper()
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, "pool.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	logfile, err := os.Create(filepath.Join(dir, "pool.db-wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log write_ahead_log
+	if err := wal_open(&log, logfile, SyncNever, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wal_close(&log) })
+	page := &disk_page{buf: make([]byte, PAGE_SIZE)}
+	for i := 0; i < n; i++ {
+		page.buf[0] = byte(i)
+		page_seal(page)
+		if _, err := file.WriteAt(page.buf, int64(i)*PAGE_SIZE); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var pool buffer_pool
+	pool_init(&pool, file, &log, 0)
+	return &pool
+}
+
+func pool_test_fetch(t *testing.T, pool *buffer_pool, id pgid) *disk_page {
+	t.Helper()
+	page, err := pool_fetch(pool, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.buf[0] != byte(id) {
+		t.Fatalf("page %d holds %d", id, page.buf[0])
+	}
+	return page
+}
+
+func TestPoolSizeLimit(t *testing.T) {
+	const n = 4 * MIN_CACHE_PAGES
+	pool := pool_test_open(t, n)
+	for id := pgid(0); id < n; id++ {
+		page := pool_test_fetch(t, pool, id)
+		/* dirty every other page, the change must survive eviction */
+		if id%2 == 0 {
+			page.buf[1] = 0xff
+			page.dirty = true
+			page_seal(page)
+		}
+		pool_unpin(pool, page)
+		if len(pool.frames) > MIN_CACHE_PAGES {
+			t.Fatalf("pool holds %d frames with none pinned, limit %d", len(pool.frames), MIN_CACHE_PAGES)
+		}
+	}
+	if stats := pool_stats(pool); stats.Evictions != n-MIN_CACHE_PAGES || stats.Misses != n || stats.Pages != MIN_CACHE_PAGES {
+		t.Fatalf("CacheStats = %+v after %d misses into %d frames", stats, n, MIN_CACHE_PAGES)
+	}
+	for id := pgid(0); id < n; id++ {
+		page := pool_test_fetch(t, pool, id)
+		want := byte(0)
+		if id%2 == 0 {
+			want = 0xff
+		}
+		if page.buf[1] != want {
+			t.Fatalf("page %d holds %#x after eviction, want %#x", id, page.buf[1], want)
+		}
+		pool_unpin(pool, page)
+	}
+}
+
+func TestPoolSecondChance(t *testing.T) {
+	pool := pool_test_open(t, MIN_CACHE_PAGES+1)
+	for id := pgid(0); id < MIN_CACHE_PAGES; id++ {
+		pool_unpin(pool, pool_test_fetch(t, pool, id))
+	}
+	/* only the page under the hand has been referenced since the last sweep */
+	for _, page := range pool.frames {
+		page.ref = false
+	}
+	spared := pool.frames[pool.hand]
+	spared.ref = true
+	victim := pool.frames[(pool.hand+1)%len(pool.frames)]
+
+	pool_unpin(pool, pool_test_fetch(t, pool, MIN_CACHE_PAGES))
+	if _, ok := pool.table[spared.id]; !ok {
+		t.Fatalf("page %d was evicted though its reference bit was set", spared.id)
+	}
+	if spared.ref {
+		t.Fatalf("the hand passed page %d without clearing its reference bit", spared.id)
+	}
+	if _, ok := pool.table[victim.id]; ok {
+		t.Fatalf("page %d, next after the hand and unreferenced, was kept", victim.id)
+	}
+}
+
+func TestPoolPinned(t *testing.T) {
+	const n = 2 * MIN_CACHE_PAGES
+	pool := pool_test_open(t, n+1)
+	var pinned []*disk_page
+	for id := pgid(0); id < n; id++ {
+		pinned = append(pinned, pool_test_fetch(t, pool, id))
+	}
+	/* every frame is pinned, so the pool grows rather than evict */
+	if stats := pool_stats(pool); stats.Evictions != 0 || stats.Pages != n {
+		t.Fatalf("CacheStats = %+v with %d pages pinned", stats, n)
+	}
+	for _, page := range pinned {
+		if pool.table[page.id] != page {
+			t.Fatalf("pinned page %d left the pool", page.id)
+		}
+	}
+
+	/* unpinned frames are given up again on the next miss, pinned ones never */
+	for _, page := range pinned[1:] {
+		pool_unpin(pool, page)
+	}
+	pool_unpin(pool, pool_test_fetch(t, pool, n))
+	if got := len(pool.frames); got != MIN_CACHE_PAGES {
+		t.Fatalf("pool holds %d frames after a miss, want %d", got, MIN_CACHE_PAGES)
+	}
+	if pool.table[pinned[0].id] != pinned[0] {
+		t.Fatal("the page still pinned was evicted")
+	}
+}
+
+func TestPoolSyncsLogBeforeWriteBack(t *testing.T) {
+	pool := pool_test_open(t, MIN_CACHE_PAGES+1)
+	page := pool_test_fetch(t, pool, 0)
+	lsn, err := wal_append(pool.wal, disk_meta{npages: MIN_CACHE_PAGES + 1}, []*disk_page{page})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.buf[1] = 0xff
+	page.dirty = true
+	page.lsn = lsn
+	page_seal(page)
+	pool_unpin(pool, page)
+	if pool.wal.synced >= lsn {
+		t.Fatalf("record %d was forced under SyncNever before any write-back", lsn)
+	}
+
+	/* filling the pool evicts the dirty page, whose record must reach the disk first */
+	for id := pgid(1); id <= MIN_CACHE_PAGES; id++ {
+		pool_unpin(pool, pool_test_fetch(t, pool, id))
+	}
+	if _, ok := pool.table[0]; ok {
+		t.Fatal("page 0 was not evicted")
+	}
+	if pool.wal.synced < lsn {
+		t.Fatalf("page 0 was written back with its record %d not yet forced, synced up to %d", lsn, pool.wal.synced)
+	}
+}
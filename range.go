@@ -0,0 +1,136 @@
+package bplustree
+
+import "iter"
+
+// RangeOption adjusts the bounds of a range scan. By default both ends
+// of a range are inclusive.
+type RangeOption func(*rangeConfig)
+
+type rangeConfig struct {
+	flags int
+}
+
+// ExcludeLow makes a range scan skip a key equal to its lower bound.
+func ExcludeLow() RangeOption {
+	return func(c *rangeConfig) {
+		c.flags |= RANGE_EXCLUDE_MIN
+	}
+}
+
+// ExcludeHigh makes a range scan stop before a key equal to its upper
+// bound.
+func ExcludeHigh() RangeOption {
+	return func(c *rangeConfig) {
+		c.flags |= RANGE_EXCLUDE_MAX
+	}
+}
+
+func rangeFlags(opts []RangeOption) int {
+	var c rangeConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c.flags
+}
+
+// scan feeds fn the entries selected by min, max and flags, walking
+// forwards or backwards. Entries are copied out a leaf's worth at a time
+// under the reader lock, and the lock is released while fn runs so that
+// fn may call back into the tree. Each batch resumes just past the last
+// key handed to fn, so changes made during a scan may or may not be
+// observed by the rest of it, but no key is visited twice.
+func (t *Tree[K, V]) scan(min, max K, flags int, reverse bool, fn func(key K, value V) bool) {
+	var keys []K
+	var values []V
+	for {
+		keys, values = keys[:0], values[:0]
+		t.mu.RLock()
+		batch := t.tree.entries
+		collect := func(key K, data V) bool {
+			keys = append(keys, key)
+			values = append(values, data)
+			return len(keys) < batch
+		}
+		if reverse {
+			bplus_tree_get_range_reverse(t.tree, max, min, flags, collect)
+		} else {
+			bplus_tree_get_range(t.tree, min, max, flags, collect)
+		}
+		t.mu.RUnlock()
+		for i := range keys {
+			if !fn(keys[i], values[i]) {
+				return
+			}
+		}
+		if len(keys) < batch {
+			return
+		}
+		if reverse {
+			max = keys[len(keys)-1]
+			flags = flags&^RANGE_NO_MAX | RANGE_EXCLUDE_MAX
+		} else {
+			min = keys[len(keys)-1]
+			flags = flags&^RANGE_NO_MIN | RANGE_EXCLUDE_MIN
+		}
+	}
+}
+
+// AscendRange calls fn for every key between lo and hi, in ascending
+// order, until fn returns false. Both bounds are inclusive unless
+// adjusted by opts; the range is empty if lo sorts after hi. fn may
+// modify the tree; see Range for how such changes are observed.
+func (t *Tree[K, V]) AscendRange(lo, hi K, fn func(key K, value V) bool, opts ...RangeOption) {
+	t.scan(lo, hi, rangeFlags(opts), false, fn)
+}
+
+// Range returns an iterator over the keys between lo and hi in
+// ascending order, with the same bounds as AscendRange:
+//
+//	for k, v := range t.Range(lo, hi) {
+//		...
+//	}
+//
+// The scan does not hold the tree locked while the loop body runs, so
+// the body may read or modify the tree. Keys inserted or deleted ahead
+// of the scan position may or may not be observed; keys already visited
+// are never visited again.
+func (t *Tree[K, V]) Range(lo, hi K, opts ...RangeOption) iter.Seq2[K, V] {
+	flags := rangeFlags(opts)
+	return func(yield func(K, V) bool) {
+		t.scan(lo, hi, flags, false, yield)
+	}
+}
+
+// Ascend calls fn for every key in ascending order until fn returns
+// false.
+func (t *Tree[K, V]) Ascend(fn func(key K, value V) bool) {
+	var zero K
+	t.scan(zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, false, fn)
+}
+
+// AscendGreaterOrEqual calls fn for every key greater than or equal to
+// pivot, in ascending order, until fn returns false.
+func (t *Tree[K, V]) AscendGreaterOrEqual(pivot K, fn func(key K, value V) bool) {
+	t.scan(pivot, pivot, RANGE_NO_MAX, false, fn)
+}
+
+// Descend calls fn for every key in descending order until fn returns
+// false.
+func (t *Tree[K, V]) Descend(fn func(key K, value V) bool) {
+	var zero K
+	t.scan(zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, true, fn)
+}
+
+// DescendRange calls fn for every key between hi and lo, in descending
+// order, until fn returns false. Both bounds are inclusive unless
+// adjusted by opts, where ExcludeHigh applies to hi and ExcludeLow to
+// lo; the range is empty if hi sorts before lo.
+func (t *Tree[K, V]) DescendRange(hi, lo K, fn func(key K, value V) bool, opts ...RangeOption) {
+	t.scan(lo, hi, rangeFlags(opts), true, fn)
+}
+
+// DescendLessOrEqual calls fn for every key less than or equal to
+// pivot, in descending order, until fn returns false.
+func (t *Tree[K, V]) DescendLessOrEqual(pivot K, fn func(key K, value V) bool) {
+	t.scan(pivot, pivot, RANGE_NO_MIN, true, fn)
+}
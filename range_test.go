@@ -0,0 +1,168 @@
+package bplustree
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRangeBounds(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for k := 10; k <= 100; k += 10 {
+		tree.Put(k, k*2)
+	}
+	tests := []struct {
+		name   string
+		lo, hi int
+		opts   []RangeOption
+		want   []int
+	}{
+		{name: "all", lo: 0, hi: 1000, want: []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}},
+		{name: "inclusive", lo: 30, hi: 60, want: []int{30, 40, 50, 60}},
+		{name: "between keys", lo: 25, hi: 65, want: []int{30, 40, 50, 60}},
+		{name: "exclude low", lo: 30, hi: 60, opts: []RangeOption{ExcludeLow()}, want: []int{40, 50, 60}},
+		{name: "exclude high", lo: 30, hi: 60, opts: []RangeOption{ExcludeHigh()}, want: []int{30, 40, 50}},
+		{name: "exclude both", lo: 30, hi: 60, opts: []RangeOption{ExcludeLow(), ExcludeHigh()}, want: []int{40, 50}},
+		{name: "single key", lo: 30, hi: 30, want: []int{30}},
+		{name: "single key excluded", lo: 30, hi: 30, opts: []RangeOption{ExcludeHigh()}},
+		{name: "gap", lo: 41, hi: 49},
+		{name: "reversed", lo: 60, hi: 30},
+		{name: "past the end", lo: 101, hi: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for k, v := range tree.Range(tt.lo, tt.hi, tt.opts...) {
+				if v != k*2 {
+					t.Fatalf("Range yielded %d => %d, want %d", k, v, k*2)
+				}
+				got = append(got, k)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Range(%d, %d) = %v, want %v", tt.lo, tt.hi, got, tt.want)
+			}
+			got = got[:0]
+			tree.AscendRange(tt.lo, tt.hi, func(k, _ int) bool {
+				got = append(got, k)
+				return true
+			}, tt.opts...)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("AscendRange(%d, %d) = %v, want %v", tt.lo, tt.hi, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeStopsEarly(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for k := 0; k < 100; k++ {
+		tree.Put(k, k)
+	}
+	var got []int
+	for k := range tree.Range(10, 90) {
+		if k == 13 {
+			break
+		}
+		got = append(got, k)
+	}
+	if want := []int{10, 11, 12}; !slices.Equal(got, want) {
+		t.Fatalf("Range with break = %v, want %v", got, want)
+	}
+}
+
+func TestRangeDirections(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for k := 10; k <= 100; k += 10 {
+		tree.Put(k, k*2)
+	}
+	tests := []struct {
+		name string
+		scan func(fn func(k, v int) bool)
+		want []int
+	}{
+		{name: "Ascend", scan: tree.Ascend, want: []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}},
+		{name: "Descend", scan: tree.Descend, want: []int{100, 90, 80, 70, 60, 50, 40, 30, 20, 10}},
+		{
+			name: "AscendGreaterOrEqual on a key",
+			scan: func(fn func(k, v int) bool) { tree.AscendGreaterOrEqual(70, fn) },
+			want: []int{70, 80, 90, 100},
+		},
+		{
+			name: "AscendGreaterOrEqual between keys",
+			scan: func(fn func(k, v int) bool) { tree.AscendGreaterOrEqual(75, fn) },
+			want: []int{80, 90, 100},
+		},
+		{
+			name: "DescendLessOrEqual on a key",
+			scan: func(fn func(k, v int) bool) { tree.DescendLessOrEqual(30, fn) },
+			want: []int{30, 20, 10},
+		},
+		{
+			name: "DescendLessOrEqual between keys",
+			scan: func(fn func(k, v int) bool) { tree.DescendLessOrEqual(35, fn) },
+			want: []int{30, 20, 10},
+		},
+		{
+			name: "DescendLessOrEqual below the first key",
+			scan: func(fn func(k, v int) bool) { tree.DescendLessOrEqual(5, fn) },
+		},
+		{
+			name: "DescendRange",
+			scan: func(fn func(k, v int) bool) { tree.DescendRange(65, 25, fn) },
+			want: []int{60, 50, 40, 30},
+		},
+		{
+			name: "DescendRange inclusive",
+			scan: func(fn func(k, v int) bool) { tree.DescendRange(60, 30, fn) },
+			want: []int{60, 50, 40, 30},
+		},
+		{
+			name: "DescendRange exclude high",
+			scan: func(fn func(k, v int) bool) { tree.DescendRange(60, 30, fn, ExcludeHigh()) },
+			want: []int{50, 40, 30},
+		},
+		{
+			name: "DescendRange exclude low",
+			scan: func(fn func(k, v int) bool) { tree.DescendRange(60, 30, fn, ExcludeLow()) },
+			want: []int{60, 50, 40},
+		},
+		{
+			name: "DescendRange reversed",
+			scan: func(fn func(k, v int) bool) { tree.DescendRange(30, 60, fn) },
+		},
+		{
+			name: "DescendRange above the last key",
+			scan: func(fn func(k, v int) bool) { tree.DescendRange(500, 95, fn) },
+			want: []int{100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			tt.scan(func(k, v int) bool {
+				if v != k*2 {
+					t.Fatalf("scan yielded %d => %d, want %d", k, v, k*2)
+				}
+				got = append(got, k)
+				return true
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeDescendStopsEarly(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for k := 0; k < 100; k++ {
+		tree.Put(k, k)
+	}
+	var got []int
+	tree.Descend(func(k, _ int) bool {
+		got = append(got, k)
+		return len(got) < 3
+	})
+	if want := []int{99, 98, 97}; !slices.Equal(got, want) {
+		t.Fatalf("Descend stopping after 3 = %v, want %v", got, want)
+	}
+}
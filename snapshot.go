@@ -0,0 +1,339 @@
+package bplustree
+
+import "iter"
+
+/*
+ * Copy-on-write snapshots
+ *
+ * Every node records the generation it was created in. Taking a snapshot
+ * bumps tree.gen, after which every existing node is shared between the
+ * live tree and the snapshot and must not change. Before a writer touches
+ * a shared node it copies the node along with its path to the root, so a
+ * snapshot keeps seeing the nodes it started with.
+ *
+ * Only the keys, data and sub-node pointers of a shared node are frozen.
+ * The parent, prev and next links describe the live tree, are updated on
+ * shared nodes as copies replace their neighbors, and are never read by
+ * a snapshot, which walks the tree from its root instead.
+ */
+
+func node_gen[K, V any](node bplus_node[K, V]) uint64 {
+	switch n := node.(type) {
+	case *bplus_non_leaf[K, V]:
+		return n.gen
+	case *bplus_leaf[K, V]:
+		return n.gen
+	}
+	return 0
+}
+
+/* replace old with node among the sub-nodes of parent, or at the root */
+func node_replace[K, V any](tree *bplus_tree[K, V], parent *bplus_non_leaf[K, V], old, node bplus_node[K, V]) {
+	if parent == nil {
+		tree.root = node
+		return
+	}
+	for i := 0; i < parent.children; i++ {
+		if parent.sub_ptr[i] == old {
+			parent.sub_ptr[i] = node
+			return
+		}
+	}
+	assert(false, 38)
+}
+
+/* the node to the left of node on its level */
+func non_leaf_left[K, V any](tree *bplus_tree[K, V], node bplus_node[K, V]) *bplus_non_leaf[K, V] {
+
+	var parent *bplus_non_leaf[K, V] = node.getParent()
+	if parent == nil {
+		return nil
+	}
+	for i := 1; i < parent.children; i++ {
+		if parent.sub_ptr[i] == node {
+			return parent.sub_ptr[i-1].(*bplus_non_leaf[K, V])
+		}
+	}
+	var left *bplus_non_leaf[K, V] = non_leaf_left(tree, parent)
+	if left == nil {
+		return nil
+	}
+	return left.sub_ptr[left.children-1].(*bplus_non_leaf[K, V])
+}
+
+/* make node writable, copying it and its ancestors if they are shared */
+func non_leaf_cow[K, V any](tree *bplus_tree[K, V], node *bplus_non_leaf[K, V]) *bplus_non_leaf[K, V] {
+
+	if node.gen == tree.gen {
+		return node
+	}
+
+	var parent *bplus_non_leaf[K, V] = node.parent
+	if parent != nil {
+		parent = non_leaf_cow(tree, parent)
+	}
+
+	var clone *bplus_non_leaf[K, V] = non_leaf_new(tree)
+	latch_copy(tree, &clone.latch)
+	clone.parent = parent
+	clone.next = node.next
+	clone.children = node.children
+	copy(clone.key, node.key[:node.children-1])
+	copy(clone.sub_ptr, node.sub_ptr[:node.children])
+	for i := 0; i < clone.children; i++ {
+		clone.sub_ptr[i].setParent(clone)
+	}
+
+	if left := non_leaf_left(tree, node); left != nil {
+		left.next = clone
+	} else {
+		for i := range tree.head {
+			if tree.head[i] == bplus_node[K, V](node) {
+				tree.head[i] = clone
+			}
+		}
+	}
+	node_replace(tree, parent, node, clone)
+	return clone
+}
+
+/*
+ * Make leaf writable, copying it and its ancestors if they are shared.
+ * held is a neighbor the caller already has latched, if any.
+ */
+func leaf_cow[K, V any](tree *bplus_tree[K, V], leaf *bplus_leaf[K, V], held *bplus_leaf[K, V]) *bplus_leaf[K, V] {
+
+	if leaf.gen == tree.gen {
+		return leaf
+	}
+
+	var parent *bplus_non_leaf[K, V] = leaf.parent
+	if parent != nil {
+		parent = non_leaf_cow(tree, parent)
+	}
+
+	var clone *bplus_leaf[K, V] = leaf_new(tree)
+	latch_copy(tree, &clone.latch)
+	clone.parent = parent
+	clone.prev = leaf.prev
+	clone.next = leaf.next
+	clone.entries = leaf.entries
+	copy(clone.key, leaf.key[:leaf.entries])
+	copy(clone.data, leaf.data[:leaf.entries])
+
+	if leaf.prev != nil {
+		if leaf.prev != held {
+			latch_lock(tree, &leaf.prev.latch)
+			defer latch_unlock(tree, &leaf.prev.latch)
+		}
+		leaf.prev.next = clone
+	} else {
+		tree.head[0] = clone
+	}
+	if leaf.next != nil {
+		if leaf.next != held {
+			latch_lock(tree, &leaf.next.latch)
+			defer latch_unlock(tree, &leaf.next.latch)
+		}
+		leaf.next.prev = clone
+	}
+	node_replace(tree, parent, leaf, clone)
+	return clone
+}
+
+/* in-order walk of the subtree under node, stopping when fn returns false */
+func bplus_snapshot_range[K, V any](tree *bplus_tree[K, V], node bplus_node[K, V], min K, max K, flags int, fn func(key K, data V) bool) bool {
+
+	switch n := node.(type) {
+	case *bplus_non_leaf[K, V]:
+		var lo, hi int = 0, n.children - 1
+		if flags&RANGE_NO_MIN == 0 {
+			lo = non_leaf_index(tree, n, min)
+		}
+		if flags&RANGE_NO_MAX == 0 {
+			hi = non_leaf_index(tree, n, max)
+		}
+		for i := lo; i <= hi; i++ {
+			if !bplus_snapshot_range(tree, n.sub_ptr[i], min, max, flags, fn) {
+				return false
+			}
+		}
+	case *bplus_leaf[K, V]:
+		var i int
+		if flags&RANGE_NO_MIN == 0 {
+			i = key_binary_search(n.key, n.entries, min, tree.compare)
+			if i < 0 {
+				i = -i - 1
+			} else if flags&RANGE_EXCLUDE_MIN != 0 {
+				i++
+			}
+		}
+		for ; i < n.entries; i++ {
+			if flags&RANGE_NO_MAX == 0 {
+				c := tree.compare(n.key[i], max)
+				if c > 0 || (c == 0 && flags&RANGE_EXCLUDE_MAX != 0) {
+					return false
+				}
+			}
+			if !fn(n.key[i], n.data[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/* reverse in-order walk of the subtree under node */
+func bplus_snapshot_range_reverse[K, V any](tree *bplus_tree[K, V], node bplus_node[K, V], max K, min K, flags int, fn func(key K, data V) bool) bool {
+
+	switch n := node.(type) {
+	case *bplus_non_leaf[K, V]:
+		var lo, hi int = 0, n.children - 1
+		if flags&RANGE_NO_MIN == 0 {
+			lo = non_leaf_index(tree, n, min)
+		}
+		if flags&RANGE_NO_MAX == 0 {
+			hi = non_leaf_index(tree, n, max)
+		}
+		for i := hi; i >= lo; i-- {
+			if !bplus_snapshot_range_reverse(tree, n.sub_ptr[i], max, min, flags, fn) {
+				return false
+			}
+		}
+	case *bplus_leaf[K, V]:
+		var i int = n.entries - 1
+		if flags&RANGE_NO_MAX == 0 {
+			i = key_binary_search(n.key, n.entries, max, tree.compare)
+			if i < 0 {
+				i = -i - 2
+			} else if flags&RANGE_EXCLUDE_MAX != 0 {
+				i--
+			}
+		}
+		for ; i >= 0; i-- {
+			if flags&RANGE_NO_MIN == 0 {
+				c := tree.compare(n.key[i], min)
+				if c < 0 || (c == 0 && flags&RANGE_EXCLUDE_MIN != 0) {
+					return false
+				}
+			}
+			if !fn(n.key[i], n.data[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func bplus_snapshot_search[K, V any](tree *bplus_tree[K, V], node bplus_node[K, V], key K) (V, bool) {
+
+	for node != nil {
+		switch n := node.(type) {
+		case *bplus_non_leaf[K, V]:
+			node = non_leaf_locate(tree, n, key)
+		case *bplus_leaf[K, V]:
+			i := key_binary_search(n.key, n.entries, key, tree.compare)
+			if i >= 0 {
+				return n.data[i], true
+			}
+			node = nil
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Snapshot is a read-only view of a Tree as of the moment it was taken.
+// It is unaffected by later changes to the tree and needs no locking, so
+// it may be read from any number of goroutines while the tree is being
+// written. A snapshot holds on to the nodes it shares with the tree until
+// it is no longer referenced.
+type Snapshot[K, V any] struct {
+	tree  *bplus_tree[K, V]
+	root  bplus_node[K, V]
+	count int
+	mods  uint64 // Tree.mods when taken
+}
+
+// Snapshot returns a point-in-time view of t in constant time. From then
+// on the tree copies a node, along with its path to the root, the first
+// time it is changed, so writes after a snapshot cost more until the
+// nodes they touch have been copied once. Snapshots taken with no change
+// to the tree in between share the same view and cost nothing extra.
+func (t *Tree[K, V]) Snapshot() *Snapshot[K, V] {
+	t.mu.RLock()
+	snap := t.snap
+	t.mu.RUnlock()
+	if snap != nil && snap.mods == t.mods.Load() {
+		return snap
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot()
+}
+
+// snapshot must be called with the tree locked exclusively.
+func (t *Tree[K, V]) snapshot() *Snapshot[K, V] {
+	if snap := t.snap; snap != nil && snap.mods == t.mods.Load() {
+		return snap
+	}
+	t.tree.gen++
+	snap := &Snapshot[K, V]{tree: t.tree, root: t.tree.root, count: int(t.count.Load()), mods: t.mods.Load()}
+	t.snap = snap
+	return snap
+}
+
+// Get returns the value stored under key when the snapshot was taken and
+// reports whether key was present.
+func (s *Snapshot[K, V]) Get(key K) (V, bool) {
+	return bplus_snapshot_search(s.tree, s.root, key)
+}
+
+// Len returns the number of keys in the snapshot.
+func (s *Snapshot[K, V]) Len() int {
+	return s.count
+}
+
+// AscendRange calls fn for every key between lo and hi, in ascending
+// order, until fn returns false, with the same bounds as
+// Tree.AscendRange.
+func (s *Snapshot[K, V]) AscendRange(lo, hi K, fn func(key K, value V) bool, opts ...RangeOption) {
+	if s.root != nil {
+		bplus_snapshot_range(s.tree, s.root, lo, hi, rangeFlags(opts), fn)
+	}
+}
+
+// Range returns an iterator over the keys between lo and hi in ascending
+// order, with the same bounds as Tree.Range.
+func (s *Snapshot[K, V]) Range(lo, hi K, opts ...RangeOption) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		s.AscendRange(lo, hi, yield, opts...)
+	}
+}
+
+// Ascend calls fn for every key in ascending order until fn returns
+// false.
+func (s *Snapshot[K, V]) Ascend(fn func(key K, value V) bool) {
+	var zero K
+	if s.root != nil {
+		bplus_snapshot_range(s.tree, s.root, zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, fn)
+	}
+}
+
+// Descend calls fn for every key in descending order until fn returns
+// false.
+func (s *Snapshot[K, V]) Descend(fn func(key K, value V) bool) {
+	var zero K
+	if s.root != nil {
+		bplus_snapshot_range_reverse(s.tree, s.root, zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, fn)
+	}
+}
+
+// DescendRange calls fn for every key between hi and lo, in descending
+// order, until fn returns false, with the same bounds as
+// Tree.DescendRange.
+func (s *Snapshot[K, V]) DescendRange(hi, lo K, fn func(key K, value V) bool, opts ...RangeOption) {
+	if s.root != nil {
+		bplus_snapshot_range_reverse(s.tree, s.root, hi, lo, rangeFlags(opts), fn)
+	}
+}
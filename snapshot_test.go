@@ -0,0 +1,185 @@
+package bplustree
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+/* check a snapshot holds exactly the model, through every way of reading it */
+func snapshot_check(t *testing.T, snap *Snapshot[int, int], model map[int]int) {
+	t.Helper()
+
+	n, prev := 0, -1
+	snap.Ascend(func(key, value int) bool {
+		if want, ok := model[key]; !ok || key <= prev || value != want {
+			t.Fatalf("Ascend gave %d=%d after %d, want %d, %v", key, value, prev, want, ok)
+		}
+		prev = key
+		n++
+		return true
+	})
+	if n != len(model) || snap.Len() != len(model) {
+		t.Fatalf("Ascend gave %d keys, Len() = %d, want %d", n, snap.Len(), len(model))
+	}
+	n, prev = 0, 1<<40
+	snap.Descend(func(key, _ int) bool {
+		if key >= prev {
+			t.Fatalf("Descend gave %d after %d", key, prev)
+		}
+		prev = key
+		n++
+		return true
+	})
+	if n != len(model) {
+		t.Fatalf("Descend gave %d keys, want %d", n, len(model))
+	}
+	for key, want := range model {
+		if value, ok := snap.Get(key); !ok || value != want {
+			t.Fatalf("Get(%d) = %d, %v, want %d", key, value, ok, want)
+		}
+	}
+	for lo := 0; lo < 2000; lo += 250 {
+		n, want := 0, 0
+		for key := range snap.Range(lo, lo+100, ExcludeHigh()) {
+			if key < lo || key >= lo+100 {
+				t.Fatalf("Range(%d, %d) gave %d", lo, lo+100, key)
+			}
+			n++
+		}
+		for key := range model {
+			if key >= lo && key < lo+100 {
+				want++
+			}
+		}
+		if n != want {
+			t.Fatalf("Range(%d, %d) gave %d keys, want %d", lo, lo+100, n, want)
+		}
+	}
+}
+
+/* a small fan-out, so a few keys split and merge nodes */
+func crabbing_options(crabbing bool) []Option {
+	opts := []Option{WithOrder(4), WithEntries(3)}
+	if crabbing {
+		opts = append(opts, WithLatchCrabbing())
+	}
+	return opts
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	for _, crabbing := range []bool{false, true} {
+		tree := New[int, int](crabbing_options(crabbing)...)
+		model := make(map[int]int)
+		r := rand.New(rand.NewSource(1))
+
+		type taken struct {
+			snap  *Snapshot[int, int]
+			model map[int]int
+		}
+		var snaps []taken
+		for i := 0; i < 20000; i++ {
+			key := r.Intn(2000)
+			if r.Intn(5) < 3 {
+				tree.Put(key, i)
+				model[key] = i
+			} else {
+				tree.Delete(key)
+				delete(model, key)
+			}
+			if i%2000 == 0 {
+				copied := make(map[int]int, len(model))
+				for key, value := range model {
+					copied[key] = value
+				}
+				snaps = append(snaps, taken{tree.Snapshot(), copied})
+			}
+			/* every snapshot must be untouched by the splits and merges since */
+			if i%2000 == 1999 {
+				for _, s := range snaps {
+					snapshot_check(t, s.snap, s.model)
+				}
+			}
+		}
+
+		/* emptying the tree merges every node a snapshot still shares */
+		for key := range model {
+			tree.Delete(key)
+		}
+		for _, s := range snaps {
+			snapshot_check(t, s.snap, s.model)
+		}
+		if tree.Len() != 0 {
+			t.Fatalf("crabbing %v: Len() = %d after deleting every key", crabbing, tree.Len())
+		}
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	for _, crabbing := range []bool{false, true} {
+		tree := New[int, int](crabbing_options(crabbing)...)
+		for key := 0; key < 2000; key += 2 {
+			tree.Put(key, key)
+		}
+
+		/* a writer churns the odd keys while readers hold snapshots of the even ones */
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(2))
+			for i := 0; i < 20000; i++ {
+				key := r.Intn(1000)*2 + 1
+				if r.Intn(2) == 0 {
+					tree.Put(key, i)
+				} else {
+					tree.Delete(key)
+				}
+			}
+			close(done)
+		}()
+		for g := 0; g < 2; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					snap := tree.Snapshot()
+					var keys, values []int
+					evens := 0
+					snap.Ascend(func(key, value int) bool {
+						if key%2 == 0 {
+							if value != key {
+								t.Errorf("crabbing %v: snapshot has %d=%d", crabbing, key, value)
+							}
+							evens++
+						}
+						keys, values = append(keys, key), append(values, value)
+						return true
+					})
+					if evens != 1000 {
+						t.Errorf("crabbing %v: snapshot holds %d even keys, want 1000", crabbing, evens)
+					}
+					/* and again, after more of the writer's splits and merges */
+					n := 0
+					for key, value := range snap.Range(0, 2000) {
+						if n >= len(keys) || key != keys[n] || value != values[n] {
+							t.Errorf("crabbing %v: snapshot changed at %d", crabbing, key)
+							break
+						}
+						n++
+					}
+					if n != len(keys) {
+						t.Errorf("crabbing %v: snapshot changed from %d keys to %d", crabbing, len(keys), n)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,281 @@
+package bplustree
+
+import (
+	"cmp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tree is an in-memory B+ tree mapping keys of type K to values of
+// type V. The zero value is not usable; create trees with New or
+// NewFunc.
+//
+// A Tree is safe for concurrent use by multiple goroutines. Lookups and
+// scans share a reader lock and run in parallel; modifications take the
+// writer lock and are serialized, unless the tree was created with
+// WithLatchCrabbing.
+type Tree[K, V any] struct {
+	mu       sync.RWMutex
+	tree     *bplus_tree[K, V]
+	crabbing bool
+	count    atomic.Int64
+	mods     atomic.Uint64   // bumped on every modification, see Cursor
+	snap     *Snapshot[K, V] // the latest snapshot, reused until mods moves on
+
+	// Keys written by each modification, kept while read-write
+	// transactions are open so that they can be validated, see Tx.
+	txs   atomic.Int64
+	txmu  sync.Mutex
+	txlog []txCommit[K]
+	txset map[uint64]int // start of each open transaction
+}
+
+// Option configures a Tree created by New.
+type Option func(*config)
+
+type config struct {
+	order      int
+	entries    int
+	crabbing   bool
+	cache      int
+	sync       SyncPolicy
+	checkpoint int
+	every      time.Duration
+	readonly   bool
+}
+
+// WithOrder sets the maximum number of children of a non-leaf node.
+// It must be greater than MIN_ORDER.
+func WithOrder(order int) Option {
+	return func(c *config) {
+		c.order = order
+	}
+}
+
+// WithEntries sets the maximum number of entries held by a leaf node.
+// It must be at least MIN_ORDER.
+func WithEntries(entries int) Option {
+	return func(c *config) {
+		c.entries = entries
+	}
+}
+
+// WithLatchCrabbing gives every node its own latch so that modifications
+// no longer take the tree-wide writer lock. Writers latch nodes from the
+// root down and release them as soon as the node below cannot split or
+// merge, so writes to different parts of the key space proceed in
+// parallel. Lookups and scans latch one node at a time. Each operation
+// pays for its latches, so this mode only helps with concurrent writers.
+func WithLatchCrabbing() Option {
+	return func(c *config) {
+		c.crabbing = true
+	}
+}
+
+// WithCacheSize sets the memory, in bytes, a DiskTree may use to cache
+// pages. The cache never holds fewer than MIN_CACHE_PAGES pages, and
+// grows past its size only while more pages are in use at once. Trees
+// held in memory ignore it.
+func WithCacheSize(bytes int) Option {
+	return func(c *config) {
+		c.cache = bytes
+	}
+}
+
+// WithSyncPolicy sets when a DiskTree forces its write-ahead log to
+// stable storage. Trees held in memory ignore it.
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(c *config) {
+		c.sync = policy
+	}
+}
+
+// WithCheckpointSize makes a DiskTree checkpoint in the background once
+// its write-ahead log grows past bytes, DEFAULT_CHECKPOINT_SIZE by
+// default, or never if bytes is 0. Trees held in memory ignore it.
+func WithCheckpointSize(bytes int) Option {
+	return func(c *config) {
+		c.checkpoint = bytes
+	}
+}
+
+// WithCheckpointInterval makes a DiskTree also checkpoint in the
+// background every d. Trees held in memory ignore it.
+func WithCheckpointInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.every = d
+	}
+}
+
+// WithReadOnly opens a DiskTree for reading only. Where the platform
+// supports it the file is mapped into memory, so lookups and scans
+// decode keys and values straight from the operating system's page
+// cache, which every process reading the file shares, instead of copying
+// pages into a cache of their own; elsewhere pages are read through the
+// cache as usual. Modifications and Checkpoint return ErrReadOnly. Open
+// fails with ErrNeedsRecovery if the tree's write-ahead log holds
+// modifications not yet checkpointed, and the file must not be modified
+// while it is open read-only. Trees held in memory ignore it.
+func WithReadOnly() Option {
+	return func(c *config) {
+		c.readonly = true
+	}
+}
+
+// New returns an empty tree of naturally ordered keys configured by
+// opts. By default the tree uses DEFAULT_ORDER and DEFAULT_ENTRIES;
+// the height grows as needed. New panics if an option is out of range.
+func New[K cmp.Ordered, V any](opts ...Option) *Tree[K, V] {
+	return NewFunc[K, V](cmp.Compare[K], opts...)
+}
+
+// NewFunc is like New but orders keys with compare, which must return a
+// negative number when a < b, a positive number when a > b and zero
+// when a and b are equal. The comparator must define a strict weak
+// ordering and is used for every search, insertion, removal and range
+// scan on the tree.
+func NewFunc[K, V any](compare func(a, b K) int, opts ...Option) *Tree[K, V] {
+	c := config{
+		order:   DEFAULT_ORDER,
+		entries: DEFAULT_ENTRIES,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.order <= MIN_ORDER {
+		panic("bplustree: order out of range")
+	}
+	if c.entries < MIN_ORDER {
+		panic("bplustree: entries out of range")
+	}
+	tree := bplus_tree_init[K, V](c.order, c.entries, compare)
+	tree.crabbing = c.crabbing
+	return &Tree[K, V]{tree: tree, crabbing: c.crabbing}
+}
+
+// lock takes the lock a modification needs. In crabbing mode writers
+// share the tree lock and exclude each other with node latches instead.
+func (t *Tree[K, V]) lock() {
+	if t.crabbing {
+		t.mu.RLock()
+	} else {
+		t.mu.Lock()
+	}
+}
+
+func (t *Tree[K, V]) unlock() {
+	if t.crabbing {
+		t.mu.RUnlock()
+	} else {
+		t.mu.Unlock()
+	}
+}
+
+// Get returns the value stored under key and reports whether key was
+// present.
+func (t *Tree[K, V]) Get(key K) (V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return bplus_tree_search(t.tree, key)
+}
+
+// Put stores value under key, overwriting any existing value. It
+// returns the previous value and reports whether one was replaced.
+// Put panics if the tree's comparator is inconsistent, so that a key it
+// cannot find is nonetheless reported as present on insert.
+func (t *Tree[K, V]) Put(key K, value V) (V, bool) {
+	t.lock()
+	defer t.unlock()
+	old, replaced, err := bplus_tree_put(t.tree, key, value)
+	if err != nil {
+		panic(err)
+	}
+	if !replaced {
+		t.count.Add(1)
+	}
+	t.modified(key)
+	return old, replaced
+}
+
+// PutIfAbsent stores value under key only if key is not already
+// present. It returns ErrKeyExists, leaving the tree unchanged,
+// otherwise.
+func (t *Tree[K, V]) PutIfAbsent(key K, value V) error {
+	t.lock()
+	defer t.unlock()
+	if err := bplus_tree_insert(t.tree, key, value); err != nil {
+		return err
+	}
+	t.count.Add(1)
+	t.modified(key)
+	return nil
+}
+
+// Replace overwrites the value stored under key and returns the previous
+// value. It returns ErrNotFound, leaving the tree unchanged, if key is
+// not present.
+func (t *Tree[K, V]) Replace(key K, value V) (V, error) {
+	t.lock()
+	defer t.unlock()
+	old, err := bplus_tree_replace(t.tree, key, value)
+	if err == nil {
+		t.modified(key)
+	}
+	return old, err
+}
+
+// CompareAndSwap stores new under key only if key is present and its
+// current value equals old, and reports whether the swap happened.
+// Like sync.Map, it panics if the values being compared are not
+// comparable.
+func (t *Tree[K, V]) CompareAndSwap(key K, old, new V) bool {
+	t.lock()
+	defer t.unlock()
+	swapped := bplus_tree_compare_and_swap(t.tree, key, old, new)
+	if swapped {
+		t.modified(key)
+	}
+	return swapped
+}
+
+// Delete removes key from the tree, returning the value it held and
+// whether it was present.
+func (t *Tree[K, V]) Delete(key K) (V, bool) {
+	t.lock()
+	defer t.unlock()
+	value, err := bplus_tree_delete(t.tree, key)
+	if err != nil {
+		return value, false
+	}
+	t.count.Add(-1)
+	t.modified(key)
+	return value, true
+}
+
+// modified records a modification of keys. It must be called with the
+// tree locked for writing.
+func (t *Tree[K, V]) modified(keys ...K) {
+	seq := t.mods.Add(1)
+	if t.txs.Load() > 0 {
+		t.txmu.Lock()
+		t.txlog = append(t.txlog, txCommit[K]{seq: seq, keys: keys})
+		t.txmu.Unlock()
+	}
+}
+
+// Len returns the number of keys stored in the tree.
+func (t *Tree[K, V]) Len() int {
+	return int(t.count.Load())
+}
+
+// Close releases the nodes held by the tree. The tree must not be used
+// after Close.
+func (t *Tree[K, V]) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tree = nil
+	t.count.Store(0)
+	t.mods.Add(1)
+	return nil
+}
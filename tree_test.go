@@ -0,0 +1,451 @@
+package bplustree
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTreePutGetDelete(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for i := 0; i < 200; i++ {
+		if err := tree.PutIfAbsent(i*7%200, i); err != nil {
+			t.Fatalf("PutIfAbsent(%d) = %v on a fresh key", i*7%200, err)
+		}
+	}
+	if err := tree.PutIfAbsent(5, 99); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("PutIfAbsent of an existing key = %v, want ErrKeyExists", err)
+	}
+	if got := tree.Len(); got != 200 {
+		t.Fatalf("Len = %d, want 200", got)
+	}
+	for i := 0; i < 200; i++ {
+		if got, ok := tree.Get(i * 7 % 200); !ok || got != i {
+			t.Fatalf("Get(%d) = %d, %t, want %d, true", i*7%200, got, ok, i)
+		}
+	}
+	for k := 0; k < 200; k += 2 {
+		want, _ := tree.Get(k)
+		if got, ok := tree.Delete(k); !ok || got != want {
+			t.Fatalf("Delete(%d) = %d, %t, want %d, true", k, got, ok, want)
+		}
+	}
+	if _, ok := tree.Delete(0); ok {
+		t.Fatal("Delete of a missing key reported true")
+	}
+	if got := tree.Len(); got != 100 {
+		t.Fatalf("Len = %d, want 100", got)
+	}
+	for k := 0; k < 200; k++ {
+		if _, ok := tree.Get(k); ok != (k%2 == 1) {
+			t.Fatalf("Get(%d) found = %t after deleting the even keys", k, ok)
+		}
+	}
+}
+
+func TestTreeZeroAndNegativeValues(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	tests := []struct {
+		key, value int
+	}{
+		{key: 1, value: 0},
+		{key: 2, value: -1},
+		{key: 3, value: -100},
+	}
+	for _, tt := range tests {
+		if _, replaced := tree.Put(tt.key, tt.value); replaced {
+			t.Fatalf("Put(%d, %d) replaced a value in an empty tree", tt.key, tt.value)
+		}
+	}
+	for _, tt := range tests {
+		if got, ok := tree.Get(tt.key); !ok || got != tt.value {
+			t.Errorf("Get(%d) = %d, %t, want %d, true", tt.key, got, ok, tt.value)
+		}
+	}
+	if got, ok := tree.Get(4); ok || got != 0 {
+		t.Errorf("Get of a missing key = %d, %t, want 0, false", got, ok)
+	}
+	if got := tree.Len(); got != len(tests) {
+		t.Errorf("Len = %d, want %d", got, len(tests))
+	}
+}
+
+func TestNewPanicsOutOfRange(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "order too small", opts: []Option{WithOrder(MIN_ORDER)}},
+		{name: "entries too small", opts: []Option{WithEntries(MIN_ORDER - 1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("New did not panic")
+				}
+			}()
+			New[int, int](tt.opts...)
+		})
+	}
+}
+
+func TestTreeStringKeys(t *testing.T) {
+	tree := New[string, []byte](WithOrder(4), WithEntries(3))
+	words := []string{"kiwi", "apple", "mango", "fig", "banana", "cherry", "date", "grape", "lemon"}
+	for _, w := range words {
+		tree.Put(w, []byte(w))
+	}
+	for _, w := range words {
+		if got, _ := tree.Get(w); string(got) != w {
+			t.Fatalf("Get(%q) = %q", w, got)
+		}
+	}
+	if _, ok := tree.Get("zucchini"); ok {
+		t.Fatal("Get of a missing key reported true")
+	}
+	var got []string
+	for k := range tree.Range("c", "e") {
+		got = append(got, k)
+	}
+	if want := []string{"cherry", "date"}; !slices.Equal(got, want) {
+		t.Fatalf(`Range("c", "e") = %q, want %q`, got, want)
+	}
+}
+
+func TestNewFuncComparators(t *testing.T) {
+	type tenant_key struct {
+		tenant string
+		ts     int
+	}
+	t.Run("reverse", func(t *testing.T) {
+		tree := NewFunc[int, int](func(a, b int) int { return cmp.Compare(b, a) }, WithOrder(4), WithEntries(3))
+		for k := 1; k <= 50; k++ {
+			tree.Put(k, k)
+		}
+		var got []int
+		for k := range tree.Range(20, 10) {
+			got = append(got, k)
+		}
+		if want := []int{20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10}; !slices.Equal(got, want) {
+			t.Fatalf("Range(20, 10) = %v, want %v", got, want)
+		}
+		for k := 1; k <= 50; k += 3 {
+			if _, ok := tree.Delete(k); !ok {
+				t.Fatalf("Delete(%d) reported false", k)
+			}
+			if _, ok := tree.Get(k); ok {
+				t.Fatalf("Get(%d) found the key after Delete", k)
+			}
+		}
+	})
+	t.Run("case-insensitive", func(t *testing.T) {
+		tree := NewFunc[string, int](func(a, b string) int {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		}, WithOrder(4), WithEntries(3))
+		tree.Put("Alpha", 1)
+		if err := tree.PutIfAbsent("ALPHA", 2); !errors.Is(err, ErrKeyExists) {
+			t.Fatalf(`PutIfAbsent("ALPHA") = %v with "Alpha" present, want ErrKeyExists`, err)
+		}
+		if got, _ := tree.Get("alpha"); got != 1 {
+			t.Fatalf(`Get("alpha") = %d, want 1`, got)
+		}
+	})
+	t.Run("composite", func(t *testing.T) {
+		tree := NewFunc[tenant_key, int](func(a, b tenant_key) int {
+			if c := strings.Compare(a.tenant, b.tenant); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.ts, b.ts)
+		}, WithOrder(4), WithEntries(3))
+		for _, tenant := range []string{"acme", "globex", "initech"} {
+			for ts := 0; ts < 20; ts++ {
+				tree.Put(tenant_key{tenant, ts}, ts)
+			}
+		}
+		n := 0
+		for k := range tree.Range(tenant_key{"globex", 0}, tenant_key{"globex", 1 << 30}) {
+			if k.tenant != "globex" {
+				t.Fatalf("Range over globex yielded %v", k)
+			}
+			n++
+		}
+		if n != 20 {
+			t.Fatalf("Range over globex yielded %d keys, want 20", n)
+		}
+		if got := tree.Len(); got != 60 {
+			t.Fatalf("Len = %d, want 60", got)
+		}
+	})
+}
+
+func TestTreeWriteSemantics(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(tree *Tree[int, string]) (string, bool)
+		want  string // value under key 1 afterwards, "" if absent
+		ret   string
+		ok    bool
+		len   int
+	}{
+		{
+			name:  "Put inserts",
+			write: func(tree *Tree[int, string]) (string, bool) { return tree.Put(2, "b") },
+			want:  "a", ret: "", ok: false, len: 2,
+		},
+		{
+			name:  "Put overwrites",
+			write: func(tree *Tree[int, string]) (string, bool) { return tree.Put(1, "z") },
+			want:  "z", ret: "a", ok: true, len: 1,
+		},
+		{
+			name: "PutIfAbsent keeps existing",
+			write: func(tree *Tree[int, string]) (string, bool) {
+				return "", errors.Is(tree.PutIfAbsent(1, "z"), ErrKeyExists)
+			},
+			want: "a", ret: "", ok: true, len: 1,
+		},
+		{
+			name: "Replace overwrites",
+			write: func(tree *Tree[int, string]) (string, bool) {
+				old, err := tree.Replace(1, "z")
+				return old, err == nil
+			},
+			want: "z", ret: "a", ok: true, len: 1,
+		},
+		{
+			name: "Replace of a missing key",
+			write: func(tree *Tree[int, string]) (string, bool) {
+				old, err := tree.Replace(2, "z")
+				return old, errors.Is(err, ErrNotFound)
+			},
+			want: "a", ret: "", ok: true, len: 1,
+		},
+		{
+			name:  "CompareAndSwap matches",
+			write: func(tree *Tree[int, string]) (string, bool) { return "", tree.CompareAndSwap(1, "a", "z") },
+			want:  "z", ret: "", ok: true, len: 1,
+		},
+		{
+			name:  "CompareAndSwap mismatches",
+			write: func(tree *Tree[int, string]) (string, bool) { return "", tree.CompareAndSwap(1, "x", "z") },
+			want:  "a", ret: "", ok: false, len: 1,
+		},
+		{
+			name:  "CompareAndSwap of a missing key",
+			write: func(tree *Tree[int, string]) (string, bool) { return "", tree.CompareAndSwap(2, "", "z") },
+			want:  "a", ret: "", ok: false, len: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := New[int, string](WithOrder(4), WithEntries(3))
+			tree.Put(1, "a")
+			ret, ok := tt.write(tree)
+			if ret != tt.ret || ok != tt.ok {
+				t.Fatalf("got %q, %t, want %q, %t", ret, ok, tt.ret, tt.ok)
+			}
+			if got, _ := tree.Get(1); got != tt.want {
+				t.Fatalf("Get(1) = %q, want %q", got, tt.want)
+			}
+			if got := tree.Len(); got != tt.len {
+				t.Fatalf("Len = %d, want %d", got, tt.len)
+			}
+		})
+	}
+}
+
+func TestTreeCompareAndSwapNotComparable(t *testing.T) {
+	tree := New[int, []int](WithOrder(4), WithEntries(3))
+	tree.Put(1, []int{1})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("CompareAndSwap on slice values did not panic")
+		}
+	}()
+	tree.CompareAndSwap(1, []int{1}, []int{2})
+}
+
+// TestTreeHeight grows the tree past the fixed height of the original
+// C tree, then deletes every key and checks that it shrinks back.
+func TestTreeHeight(t *testing.T) {
+	const n = 100000
+	tests := []struct {
+		name string
+		key  func(i int) int
+	}{
+		{name: "ascending", key: func(i int) int { return i }},
+		{name: "descending", key: func(i int) int { return n - i }},
+		{name: "interleaved", key: func(i int) int { return i * 7919 % n }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := New[int, int](WithOrder(4), WithEntries(3))
+			height := 0
+			for i := 0; i < n; i++ {
+				tree.Put(tt.key(i), i)
+				if h := len(tree.tree.head); h < height {
+					t.Fatalf("height dropped from %d to %d on insert", height, h)
+				} else {
+					height = h
+				}
+			}
+			if height <= 10 {
+				t.Fatalf("height after %d inserts = %d, want more than 10", n, height)
+			}
+			for i := 0; i < n; i++ {
+				if _, ok := tree.Get(tt.key(i)); !ok {
+					t.Fatalf("Get(%d) lost at height %d", tt.key(i), height)
+				}
+			}
+			for i := 0; i < n; i++ {
+				if _, ok := tree.Delete(tt.key(i)); !ok {
+					t.Fatalf("Delete(%d) reported false", tt.key(i))
+				}
+				if h := len(tree.tree.head); h > height {
+					t.Fatalf("height grew from %d to %d on delete", height, h)
+				} else {
+					height = h
+				}
+			}
+			if height != 0 || tree.tree.root != nil {
+				t.Fatalf("height after deleting every key = %d, want 0", height)
+			}
+			tree.Put(1, 1)
+			if got := len(tree.tree.head); got != 1 {
+				t.Fatalf("height after re-inserting into an emptied tree = %d, want 1", got)
+			}
+		})
+	}
+}
+
+// TestTreeGeometries runs the same workload across node sizes from the
+// smallest allowed up to fan-outs larger than the old compile-time caps.
+func TestTreeGeometries(t *testing.T) {
+	tests := []struct {
+		order, entries int
+	}{
+		{order: MIN_ORDER + 1, entries: MIN_ORDER},
+		{order: 5, entries: 8},
+		{order: DEFAULT_ORDER, entries: DEFAULT_ENTRIES},
+		{order: 300, entries: 500},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.order, tt.entries), func(t *testing.T) {
+			tree := New[int, int](WithOrder(tt.order), WithEntries(tt.entries))
+			const n = 5000
+			for i := 0; i < n; i++ {
+				tree.Put(i*7919%n, i)
+			}
+			if got := cap(tree.tree.head[0].(*bplus_leaf[int, int]).key); got != tt.entries {
+				t.Fatalf("leaf key capacity = %d, want %d", got, tt.entries)
+			}
+			for k := 0; k < n; k += 2 {
+				tree.Delete(k)
+			}
+			k := 1
+			for key := range tree.Range(0, n) {
+				if key != k {
+					t.Fatalf("Range yielded %d, want %d", key, k)
+				}
+				k += 2
+			}
+			if k != n+1 {
+				t.Fatalf("Range stopped before %d", k)
+			}
+		})
+	}
+}
+
+// TestTreeConcurrent shares one tree between writers and readers; run
+// it with -race.
+func TestTreeConcurrent(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	const writers, keys = 4, 1000
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for k := w; k < keys; k += writers {
+				tree.Put(k, k)
+				if k%3 == 0 {
+					tree.Delete(k)
+				}
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				prev := -1
+				for k, v := range tree.Range(0, keys) {
+					if k <= prev || v != k {
+						t.Errorf("Range yielded %d => %d after %d", k, v, prev)
+						return
+					}
+					prev = k
+				}
+				c := tree.Cursor()
+				for ok := c.Last(); ok; ok = c.Prev() {
+				}
+				tree.Get(prev)
+				tree.Len()
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	wg.Wait()
+	want := 0
+	for k := 0; k < keys; k++ {
+		_, ok := tree.Get(k)
+		if ok != (k%3 != 0) {
+			t.Fatalf("Get(%d) found = %t", k, ok)
+		}
+		if ok {
+			want++
+		}
+	}
+	if got := tree.Len(); got != want {
+		t.Fatalf("Len = %d, want %d", got, want)
+	}
+}
+
+// TestTreeModifyDuringScan deletes every key a scan visits and inserts
+// keys ahead of it; the scan must not deadlock or visit a key twice.
+func TestTreeModifyDuringScan(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for k := 0; k < 100; k += 2 {
+		tree.Put(k, k)
+	}
+	seen := map[int]bool{}
+	for k := range tree.Range(0, 200) {
+		if seen[k] {
+			t.Fatalf("Range visited %d twice", k)
+		}
+		seen[k] = true
+		tree.Delete(k)
+		if k < 100 {
+			tree.Put(k+101, k+101)
+		}
+	}
+	for k := 0; k < 100; k += 2 {
+		if !seen[k] {
+			t.Fatalf("Range skipped %d, present before the scan", k)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package bplustree
+
+import "iter"
+
+// Tx is a transaction on a Tree, passed to the function given to Update
+// or View. Reads see the tree as of the moment the transaction began,
+// together with the transaction's own writes. Writes are buffered in the
+// transaction and applied to the tree all at once when it commits.
+//
+// Read-write transactions are serializable. They remember the keys and
+// ranges they read from the tree, and commit fails with ErrConflict if
+// any modification since the transaction began, by another transaction
+// or by a plain Put or Delete, wrote one of those keys or a key inside
+// one of those ranges. Reads of the transaction's own writes do not
+// count, and writes without a read never conflict.
+//
+// A Tx must only be used by one goroutine, and only until the function
+// it was passed to returns.
+type Tx[K, V any] struct {
+	t        *Tree[K, V]
+	snap     *Snapshot[K, V]
+	start    uint64
+	writes   *bplus_tree[K, txWrite[V]]
+	reads    *bplus_tree[K, struct{}]
+	ranges   []txRange[K]
+	writable bool
+	done     bool
+}
+
+type txWrite[V any] struct {
+	value   V
+	deleted bool
+}
+
+type txRange[K any] struct {
+	lo, hi K
+	flags  int
+}
+
+// txCommit is the set of keys written by one modification of a tree.
+type txCommit[K any] struct {
+	seq  uint64
+	keys []K
+}
+
+// Update runs fn in a read-write transaction. If fn returns nil the
+// transaction's writes become visible together, as one modification of
+// the tree; if fn returns an error, or panics, they are discarded and
+// the tree is left untouched. Update returns the error from fn, or
+// ErrConflict if the transaction could not be committed.
+func (t *Tree[K, V]) Update(fn func(tx *Tx[K, V]) error) error {
+	tx := t.begin(true)
+	defer func() {
+		tx.done = true
+		t.end(tx)
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// View runs fn in a read-only transaction and returns the error from
+// fn. Writing through the transaction returns ErrTxReadOnly.
+func (t *Tree[K, V]) View(fn func(tx *Tx[K, V]) error) error {
+	tx := t.begin(false)
+	defer func() {
+		tx.done = true
+	}()
+	return fn(tx)
+}
+
+func (t *Tree[K, V]) begin(writable bool) *Tx[K, V] {
+	if !writable {
+		/* nothing to validate, any snapshot as of now will do */
+		return &Tx[K, V]{t: t, snap: t.Snapshot()}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tx := &Tx[K, V]{t: t, snap: t.snapshot(), start: t.mods.Load(), writable: true}
+	/* from here on every modification is logged until end */
+	t.txmu.Lock()
+	if t.txset == nil {
+		t.txset = make(map[uint64]int)
+	}
+	t.txset[tx.start]++
+	t.txs.Add(1)
+	t.txmu.Unlock()
+	return tx
+}
+
+// end unregisters a finished read-write transaction and drops the log
+// entries no open transaction can conflict with any more.
+func (t *Tree[K, V]) end(tx *Tx[K, V]) {
+	if !tx.writable {
+		return
+	}
+	t.txmu.Lock()
+	defer t.txmu.Unlock()
+	if t.txset[tx.start]--; t.txset[tx.start] == 0 {
+		delete(t.txset, tx.start)
+	}
+	t.txs.Add(-1)
+	oldest := t.mods.Load()
+	for start := range t.txset {
+		oldest = min(oldest, start)
+	}
+	i := 0
+	for i < len(t.txlog) && t.txlog[i].seq <= oldest {
+		t.txlog[i] = txCommit[K]{}
+		i++
+	}
+	t.txlog = t.txlog[i:]
+}
+
+// conflicts reports whether a modification since the transaction began
+// wrote a key it read. It must be called with the tree locked.
+func (tx *Tx[K, V]) conflicts() bool {
+	if tx.reads == nil && tx.ranges == nil {
+		return false
+	}
+	t := tx.t
+	compare := t.tree.compare
+	t.txmu.Lock()
+	defer t.txmu.Unlock()
+	for _, c := range t.txlog {
+		if c.seq <= tx.start {
+			continue
+		}
+		for _, key := range c.keys {
+			if tx.reads != nil {
+				if _, ok := bplus_tree_search(tx.reads, key); ok {
+					return true
+				}
+			}
+			for _, r := range tx.ranges {
+				if inRange(compare, key, r.lo, r.hi, r.flags) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// commit validates the transaction and applies the buffered writes under
+// the writer lock.
+func (tx *Tx[K, V]) commit() error {
+	if tx.writes == nil {
+		/* a read-only transaction is serialized at its snapshot */
+		return nil
+	}
+	t := tx.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tx.conflicts() {
+		return ErrConflict
+	}
+	var keys []K
+	var zero K
+	bplus_tree_get_range(tx.writes, zero, zero, RANGE_NO_MIN|RANGE_NO_MAX, func(key K, w txWrite[V]) bool {
+		if w.deleted {
+			if _, err := bplus_tree_delete(t.tree, key); err == nil {
+				t.count.Add(-1)
+			}
+		} else if _, replaced, err := bplus_tree_put(t.tree, key, w.value); err != nil {
+			panic(err)
+		} else if !replaced {
+			t.count.Add(1)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	t.modified(keys...)
+	return nil
+}
+
+// read records a key read from the tree by a read-write transaction.
+func (tx *Tx[K, V]) read(key K) {
+	if !tx.writable {
+		return
+	}
+	if tx.reads == nil {
+		tx.reads = bplus_tree_init[K, struct{}](DEFAULT_ORDER, DEFAULT_ENTRIES, tx.t.tree.compare)
+	}
+	bplus_tree_put(tx.reads, key, struct{}{})
+}
+
+// Get returns the value of key as seen by the transaction and reports
+// whether key is present. Get panics with ErrTxDone if called after the
+// transaction has ended.
+func (tx *Tx[K, V]) Get(key K) (V, bool) {
+	var zero V
+	if tx.done {
+		panic(ErrTxDone)
+	}
+	if tx.writes != nil {
+		if w, ok := bplus_tree_search(tx.writes, key); ok {
+			if w.deleted {
+				return zero, false
+			}
+			return w.value, true
+		}
+	}
+	tx.read(key)
+	return tx.snap.Get(key)
+}
+
+func (tx *Tx[K, V]) write(key K, w txWrite[V]) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	if tx.writes == nil {
+		tx.writes = bplus_tree_init[K, txWrite[V]](DEFAULT_ORDER, DEFAULT_ENTRIES, tx.t.tree.compare)
+	}
+	_, _, err := bplus_tree_put(tx.writes, key, w)
+	return err
+}
+
+// Put stores value under key when the transaction commits.
+func (tx *Tx[K, V]) Put(key K, value V) error {
+	return tx.write(key, txWrite[V]{value: value})
+}
+
+// Delete removes key when the transaction commits. Deleting a key that
+// is not present is not an error.
+func (tx *Tx[K, V]) Delete(key K) error {
+	return tx.write(key, txWrite[V]{deleted: true})
+}
+
+// Range returns an iterator over the keys between lo and hi as seen by
+// the transaction, in ascending order, with the same bounds as
+// Tree.Range. Iterating panics with ErrTxDone once the transaction has
+// ended.
+func (tx *Tx[K, V]) Range(lo, hi K, opts ...RangeOption) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		if tx.done {
+			panic(ErrTxDone)
+		}
+		flags := rangeFlags(opts)
+		if tx.writable {
+			tx.ranges = append(tx.ranges, txRange[K]{lo: lo, hi: hi, flags: flags})
+		}
+		base := tx.snap.Range(lo, hi, opts...)
+		if tx.writes == nil {
+			base(yield)
+			return
+		}
+		/* copy the pending writes out, yield may add to them */
+		var keys []K
+		var writes []txWrite[V]
+		bplus_tree_get_range(tx.writes, lo, hi, flags, func(key K, w txWrite[V]) bool {
+			keys = append(keys, key)
+			writes = append(writes, w)
+			return true
+		})
+		compare := tx.t.tree.compare
+		i := 0
+		for k, v := range base {
+			for ; i < len(keys) && compare(keys[i], k) < 0; i++ {
+				if !writes[i].deleted && !yield(keys[i], writes[i].value) {
+					return
+				}
+			}
+			if i < len(keys) && compare(keys[i], k) == 0 {
+				i++
+				if writes[i-1].deleted {
+					continue
+				}
+				v = writes[i-1].value
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+		for ; i < len(keys); i++ {
+			if !writes[i].deleted && !yield(keys[i], writes[i].value) {
+				return
+			}
+		}
+	}
+}
+
+// inRange reports whether key lies between lo and hi under flags.
+func inRange[K any](compare func(a, b K) int, key, lo, hi K, flags int) bool {
+	if flags&RANGE_NO_MIN == 0 {
+		c := compare(key, lo)
+		if c < 0 || (c == 0 && flags&RANGE_EXCLUDE_MIN != 0) {
+			return false
+		}
+	}
+	if flags&RANGE_NO_MAX == 0 {
+		c := compare(key, hi)
+		if c > 0 || (c == 0 && flags&RANGE_EXCLUDE_MAX != 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,295 @@
+package bplustree
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestTxLostUpdate(t *testing.T) {
+	const workers = 8
+	const increments = 200
+	for _, crabbing := range []bool{false, true} {
+		tree := New[int, int](crabbing_options(crabbing)...)
+		tree.Put(0, 0)
+
+		/* each increment reads the counter and writes it back, retrying on conflict */
+		var wg sync.WaitGroup
+		for g := 0; g < workers; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < increments; i++ {
+					for {
+						err := tree.Update(func(tx *Tx[int, int]) error {
+							value, _ := tx.Get(0)
+							return tx.Put(0, value+1)
+						})
+						if err == nil {
+							break
+						}
+						if err != ErrConflict {
+							t.Errorf("Update: %v", err)
+							return
+						}
+					}
+					/* plain writes to other keys split nodes without conflicting */
+					tree.Put(1000+g*increments+i, i)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if value, _ := tree.Get(0); value != workers*increments {
+			t.Fatalf("crabbing %v: counter is %d after %d increments", crabbing, value, workers*increments)
+		}
+		if tree.Len() != 1+workers*increments {
+			t.Fatalf("crabbing %v: Len() = %d, want %d", crabbing, tree.Len(), 1+workers*increments)
+		}
+	}
+}
+
+func TestTxRangeConflict(t *testing.T) {
+	for _, crabbing := range []bool{false, true} {
+		tree := New[int, int](crabbing_options(crabbing)...)
+		for key := 0; key < 100; key += 10 {
+			tree.Put(key, key)
+		}
+
+		/* a key written inside a range the transaction read conflicts */
+		err := tree.Update(func(tx *Tx[int, int]) error {
+			sum := 0
+			for _, value := range tx.Range(20, 50) {
+				sum += value
+			}
+			tree.Put(35, 35)
+			return tx.Put(1000, sum)
+		})
+		if err != ErrConflict {
+			t.Fatalf("crabbing %v: write inside a read range: Update = %v, want ErrConflict", crabbing, err)
+		}
+		if _, ok := tree.Get(1000); ok {
+			t.Fatalf("crabbing %v: a conflicting transaction's write was applied", crabbing)
+		}
+
+		/* as does removing one */
+		err = tree.Update(func(tx *Tx[int, int]) error {
+			for range tx.Range(20, 50) {
+			}
+			tree.Delete(50)
+			return tx.Put(1000, 0)
+		})
+		if err != ErrConflict {
+			t.Fatalf("crabbing %v: delete at a read bound: Update = %v, want ErrConflict", crabbing, err)
+		}
+
+		/* but not one outside it, or at an excluded bound */
+		err = tree.Update(func(tx *Tx[int, int]) error {
+			for range tx.Range(20, 60, ExcludeHigh()) {
+			}
+			tree.Put(60, 60)
+			tree.Put(15, 15)
+			return tx.Put(1000, 0)
+		})
+		if err != nil {
+			t.Fatalf("crabbing %v: write outside a read range: Update = %v", crabbing, err)
+		}
+		if _, ok := tree.Get(1000); !ok {
+			t.Fatalf("crabbing %v: a committed transaction's write is missing", crabbing)
+		}
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	for _, crabbing := range []bool{false, true} {
+		tree := New[int, int](crabbing_options(crabbing)...)
+		for key := 0; key < 50; key++ {
+			tree.Put(key, key)
+		}
+
+		/* enough writes to split and merge, all discarded */
+		boom := errors.New("boom")
+		err := tree.Update(func(tx *Tx[int, int]) error {
+			for key := 0; key < 50; key += 2 {
+				tx.Delete(key)
+			}
+			for key := 100; key < 200; key++ {
+				tx.Put(key, key)
+			}
+			tx.Put(1, -1)
+			if value, _ := tx.Get(1); value != -1 {
+				t.Errorf("crabbing %v: the transaction does not see its own write", crabbing)
+			}
+			return boom
+		})
+		if err != boom {
+			t.Fatalf("crabbing %v: Update = %v, want fn's error", crabbing, err)
+		}
+		if tree.Len() != 50 {
+			t.Fatalf("crabbing %v: Len() = %d after a rollback, want 50", crabbing, tree.Len())
+		}
+		for key := 0; key < 50; key++ {
+			if value, ok := tree.Get(key); !ok || value != key {
+				t.Fatalf("crabbing %v: Get(%d) = %d, %v after a rollback", crabbing, key, value, ok)
+			}
+		}
+		if _, ok := tree.Get(100); ok {
+			t.Fatalf("crabbing %v: a rolled back write is visible", crabbing)
+		}
+	}
+}
+
+func TestTxCommit(t *testing.T) {
+	for _, crabbing := range []bool{false, true} {
+		tree := New[int, int](crabbing_options(crabbing)...)
+		for key := 0; key < 10; key++ {
+			tree.Put(key, key)
+		}
+		err := tree.Update(func(tx *Tx[int, int]) error {
+			tx.Put(3, 30)
+			tx.Delete(4)
+			tx.Delete(40)
+			tx.Put(11, 11)
+			tx.Put(-1, -1)
+			/* the tree does not see the writes before commit */
+			if _, ok := tree.Get(11); ok {
+				t.Errorf("crabbing %v: an uncommitted write is visible", crabbing)
+			}
+			var keys, values []int
+			for k, v := range tx.Range(-5, 20) {
+				keys = append(keys, k)
+				values = append(values, v)
+			}
+			if want := []int{-1, 0, 1, 2, 3, 5, 6, 7, 8, 9, 11}; !slices.Equal(keys, want) {
+				t.Errorf("crabbing %v: tx.Range keys = %v, want %v", crabbing, keys, want)
+			}
+			if want := []int{-1, 0, 1, 2, 30, 5, 6, 7, 8, 9, 11}; !slices.Equal(values, want) {
+				t.Errorf("crabbing %v: tx.Range values = %v, want %v", crabbing, values, want)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("crabbing %v: Update = %v", crabbing, err)
+		}
+		want := map[int]int{-1: -1, 0: 0, 1: 1, 2: 2, 3: 30, 5: 5, 6: 6, 7: 7, 8: 8, 9: 9, 11: 11}
+		if tree.Len() != len(want) {
+			t.Fatalf("crabbing %v: Len() = %d, want %d", crabbing, tree.Len(), len(want))
+		}
+		for key := -1; key <= 11; key++ {
+			value, ok := tree.Get(key)
+			if w, present := want[key]; ok != present || value != w {
+				t.Fatalf("crabbing %v: Get(%d) = %d, %v, want %d, %v", crabbing, key, value, ok, w, present)
+			}
+		}
+	}
+}
+
+func TestTxPanicRollback(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	tree.Put(1, 1)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic in fn did not reach the caller")
+			}
+		}()
+		tree.Update(func(tx *Tx[int, int]) error {
+			tx.Put(1, 2)
+			tx.Put(2, 2)
+			panic("boom")
+		})
+	}()
+	if value, _ := tree.Get(1); value != 1 || tree.Len() != 1 {
+		t.Fatalf("a panicking transaction left Get(1) = %d, Len() = %d", value, tree.Len())
+	}
+}
+
+func TestTxView(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	for key := 0; key < 20; key++ {
+		tree.Put(key, key)
+	}
+	var seen []*Snapshot[int, int]
+	view := func() {
+		err := tree.View(func(tx *Tx[int, int]) error {
+			seen = append(seen, tx.snap)
+			if err := tx.Put(100, 100); err != ErrTxReadOnly {
+				t.Errorf("Put in a View = %v, want ErrTxReadOnly", err)
+			}
+			if err := tx.Delete(1); err != ErrTxReadOnly {
+				t.Errorf("Delete in a View = %v, want ErrTxReadOnly", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View = %v", err)
+		}
+	}
+
+	/* views with no write in between share one generation */
+	view()
+	gen := tree.tree.gen
+	view()
+	view()
+	if tree.tree.gen != gen || seen[1] != seen[0] || seen[2] != seen[0] {
+		t.Fatalf("back to back views bumped the generation from %d to %d", gen, tree.tree.gen)
+	}
+	tree.Put(100, 100)
+	view()
+	if tree.tree.gen != gen+1 || seen[3] == seen[0] {
+		t.Fatal("a view after a write reused the old snapshot")
+	}
+	if _, ok := seen[0].Get(100); ok {
+		t.Fatal("a shared snapshot sees a later write")
+	}
+	if _, ok := seen[3].Get(100); !ok {
+		t.Fatal("a view after a write does not see it")
+	}
+
+	/* a failed write leaves nothing new to snapshot */
+	tree.PutIfAbsent(100, 0)
+	view()
+	if seen[4] != seen[3] {
+		t.Fatal("a rejected PutIfAbsent forced a new snapshot")
+	}
+}
+
+func TestTxDone(t *testing.T) {
+	tree := New[int, int](WithOrder(4), WithEntries(3))
+	tree.Put(1, 1)
+	var leaked *Tx[int, int]
+	tree.Update(func(tx *Tx[int, int]) error {
+		leaked = tx
+		return tx.Put(2, 2)
+	})
+	tests := []struct {
+		name string
+		use  func()
+	}{
+		{name: "Get", use: func() { leaked.Get(1) }},
+		{name: "Range", use: func() {
+			for range leaked.Range(0, 10) {
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != ErrTxDone {
+					t.Fatalf("%s after the transaction ended panicked with %v, want ErrTxDone", tt.name, r)
+				}
+			}()
+			tt.use()
+		})
+	}
+	if err := leaked.Put(3, 3); err != ErrTxDone {
+		t.Fatalf("Put after the transaction ended = %v, want ErrTxDone", err)
+	}
+	if err := leaked.Delete(1); err != ErrTxDone {
+		t.Fatalf("Delete after the transaction ended = %v, want ErrTxDone", err)
+	}
+	if _, ok := tree.Get(3); ok || tree.Len() != 2 {
+		t.Fatal("a write after the transaction ended reached the tree")
+	}
+}
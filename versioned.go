@@ -0,0 +1,268 @@
+package bplustree
+
+import (
+	"cmp"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// version is one committed value of a key. Versions are linked newest
+// first and never change once published, except that GC may cut off the
+// tail of a chain.
+type version[V any] struct {
+	seq     uint64
+	value   V
+	deleted bool
+	older   atomic.Pointer[version[V]]
+}
+
+// chain holds the versions of one key.
+type chain[V any] struct {
+	head atomic.Pointer[version[V]]
+}
+
+// at returns the version visible at seq, if any.
+func (c *chain[V]) at(seq uint64) *version[V] {
+	for v := c.head.Load(); v != nil; v = v.older.Load() {
+		if v.seq <= seq {
+			return v
+		}
+	}
+	return nil
+}
+
+// VersionedTree is a multi-version B+ tree. Every key keeps a chain of
+// the values committed to it, each tagged with the commit sequence that
+// wrote it, so the tree can be read as of any sequence still retained:
+// GetAt and RangeAt see exactly the commits numbered seq and below.
+//
+// History is kept until GC prunes it. GC discards every version that no
+// registered View can observe; reads at sequences older than the last GC
+// horizon that are not protected by a View may find versions missing.
+//
+// A VersionedTree is safe for concurrent use. Commits are serialized;
+// reads take no commit lock and never see a commit half applied.
+type VersionedTree[K, V any] struct {
+	mu      sync.Mutex // serializes commits and GC
+	tree    *Tree[K, *chain[V]]
+	seq     atomic.Uint64 // last committed sequence
+	count   atomic.Int64
+	rmu     sync.Mutex // guards readers and the horizon computation
+	readers map[uint64]int
+}
+
+// NewVersioned returns an empty versioned tree of naturally ordered
+// keys, configured by opts as for New.
+func NewVersioned[K cmp.Ordered, V any](opts ...Option) *VersionedTree[K, V] {
+	return NewVersionedFunc[K, V](cmp.Compare[K], opts...)
+}
+
+// NewVersionedFunc is like NewVersioned but orders keys with compare,
+// which has the same contract as for NewFunc.
+func NewVersionedFunc[K, V any](compare func(a, b K) int, opts ...Option) *VersionedTree[K, V] {
+	return &VersionedTree[K, V]{
+		tree:    NewFunc[K, *chain[V]](compare, opts...),
+		readers: make(map[uint64]int),
+	}
+}
+
+// Seq returns the sequence of the last commit, zero for a new tree.
+func (t *VersionedTree[K, V]) Seq() uint64 {
+	return t.seq.Load()
+}
+
+// commit applies fn as a single commit numbered one past the last and
+// publishes it once fn returns. fn installs any number of versions with
+// put, all at seq, and reports whether it wrote anything; if it did not,
+// no sequence is consumed and commit returns false.
+func (t *VersionedTree[K, V]) commit(fn func(seq uint64) bool) (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seq := t.seq.Load() + 1
+	if !fn(seq) {
+		return 0, false
+	}
+	t.seq.Store(seq)
+	return seq, true
+}
+
+// put prepends a version of key at seq. It must be called from commit.
+func (t *VersionedTree[K, V]) put(key K, seq uint64, value V, deleted bool) {
+	c, ok := t.tree.Get(key)
+	if !ok {
+		if deleted {
+			return
+		}
+		c = new(chain[V])
+		t.tree.Put(key, c)
+	}
+	prev := c.head.Load()
+	switch {
+	case !deleted && (prev == nil || prev.deleted):
+		t.count.Add(1)
+	case deleted && prev != nil && !prev.deleted:
+		t.count.Add(-1)
+	}
+	v := &version[V]{seq: seq, value: value, deleted: deleted}
+	v.older.Store(prev)
+	c.head.Store(v)
+}
+
+// Put commits value under key and returns the commit sequence.
+func (t *VersionedTree[K, V]) Put(key K, value V) uint64 {
+	seq, _ := t.commit(func(seq uint64) bool {
+		t.put(key, seq, value, false)
+		return true
+	})
+	return seq
+}
+
+// Delete commits the removal of key. It returns the commit sequence and
+// reports whether key was present; nothing is committed if it was not.
+func (t *VersionedTree[K, V]) Delete(key K) (uint64, bool) {
+	return t.commit(func(seq uint64) bool {
+		c, ok := t.tree.Get(key)
+		if !ok {
+			return false
+		}
+		if v := c.head.Load(); v == nil || v.deleted {
+			return false
+		}
+		var zero V
+		t.put(key, seq, zero, true)
+		return true
+	})
+}
+
+// Get returns the latest value of key and reports whether key is
+// present.
+func (t *VersionedTree[K, V]) Get(key K) (V, bool) {
+	return t.GetAt(key, t.seq.Load())
+}
+
+// GetAt returns the value of key as of commit seq and reports whether key
+// was present then. A seq older than the horizon of the last GC, and not
+// pinned by an open View, may have lost the versions it would see: GetAt
+// then reports key absent even if it was present at seq.
+func (t *VersionedTree[K, V]) GetAt(key K, seq uint64) (V, bool) {
+	var zero V
+	c, ok := t.tree.Get(key)
+	if !ok {
+		return zero, false
+	}
+	v := c.at(seq)
+	if v == nil || v.deleted {
+		return zero, false
+	}
+	return v.value, true
+}
+
+// RangeAt returns an iterator over the keys between lo and hi present as
+// of commit seq, in ascending order, with their values at that commit.
+// Bounds are as for Tree.Range. Like GetAt, it omits keys whose versions
+// at seq GC has already pruned.
+func (t *VersionedTree[K, V]) RangeAt(lo, hi K, seq uint64, opts ...RangeOption) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, c := range t.tree.Range(lo, hi, opts...) {
+			v := c.at(seq)
+			if v == nil || v.deleted {
+				continue
+			}
+			if !yield(k, v.value) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of keys present as of the last commit.
+func (t *VersionedTree[K, V]) Len() int {
+	return int(t.count.Load())
+}
+
+// View is a registered reader pinned at a commit sequence. While a view
+// is open GC keeps every version it can observe. Release it when done.
+type View[K, V any] struct {
+	t   *VersionedTree[K, V]
+	seq uint64
+}
+
+// View opens a reader at the last committed sequence.
+func (t *VersionedTree[K, V]) View() *View[K, V] {
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+	seq := t.seq.Load()
+	t.readers[seq]++
+	return &View[K, V]{t: t, seq: seq}
+}
+
+// Seq returns the commit sequence the view reads at.
+func (v *View[K, V]) Seq() uint64 {
+	return v.seq
+}
+
+// Get returns the value of key as of the view's sequence.
+func (v *View[K, V]) Get(key K) (V, bool) {
+	return v.t.GetAt(key, v.seq)
+}
+
+// Range returns an iterator over the keys between lo and hi as of the
+// view's sequence; see RangeAt.
+func (v *View[K, V]) Range(lo, hi K, opts ...RangeOption) iter.Seq2[K, V] {
+	return v.t.RangeAt(lo, hi, v.seq, opts...)
+}
+
+// Release unregisters the view. It must be called exactly once.
+func (v *View[K, V]) Release() {
+	t := v.t
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+	if t.readers[v.seq]--; t.readers[v.seq] <= 0 {
+		delete(t.readers, v.seq)
+	}
+}
+
+// horizon returns the oldest sequence any open view reads at, or the last
+// commit if there are none.
+func (t *VersionedTree[K, V]) horizon() uint64 {
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+	h := t.seq.Load()
+	for seq := range t.readers {
+		h = min(h, seq)
+	}
+	return h
+}
+
+// GC prunes the versions no open view can observe: for every key, those
+// older than the newest version at or below the oldest view's sequence.
+// Keys whose only remaining version is a removal are dropped from the
+// tree. GC returns the number of versions pruned. Commits wait while it
+// runs.
+func (t *VersionedTree[K, V]) GC() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.horizon()
+	pruned := 0
+	var dead []K
+	t.tree.Ascend(func(k K, c *chain[V]) bool {
+		v := c.at(h)
+		if v == nil {
+			return true
+		}
+		for o := v.older.Load(); o != nil; o = o.older.Load() {
+			pruned++
+		}
+		v.older.Store(nil)
+		if v.deleted && c.head.Load() == v {
+			dead = append(dead, k)
+			pruned++
+		}
+		return true
+	})
+	for _, k := range dead {
+		t.tree.Delete(k)
+	}
+	return pruned
+}
@@ -0,0 +1,160 @@
+package bplustree
+
+import (
+	"maps"
+	"testing"
+)
+
+func versioned_collect(t *VersionedTree[int, string], seq uint64) map[int]string {
+	got := map[int]string{}
+	for k, v := range t.RangeAt(0, 1000, seq) {
+		got[k] = v
+	}
+	return got
+}
+
+func TestVersionedReadAt(t *testing.T) {
+	tree := NewVersioned[int, string](WithOrder(4), WithEntries(3))
+	/* each step commits once; states[i] is the tree as of sequence i */
+	steps := []struct {
+		key    int
+		value  string
+		delete bool
+	}{
+		{key: 1, value: "a"},
+		{key: 2, value: "b"},
+		{key: 1, value: "a2"},
+		{key: 2, delete: true},
+		{key: 3, value: "c"},
+		{key: 2, value: "b2"},
+		{key: 1, delete: true},
+	}
+	states := []map[int]string{{}}
+	for i, step := range steps {
+		state := maps.Clone(states[len(states)-1])
+		var seq uint64
+		if step.delete {
+			var ok bool
+			if seq, ok = tree.Delete(step.key); !ok {
+				t.Fatalf("step %d: Delete(%d) reported false", i, step.key)
+			}
+			delete(state, step.key)
+		} else {
+			seq = tree.Put(step.key, step.value)
+			state[step.key] = step.value
+		}
+		if seq != uint64(i+1) || tree.Seq() != seq {
+			t.Fatalf("step %d committed at %d, Seq %d, want %d", i, seq, tree.Seq(), i+1)
+		}
+		states = append(states, state)
+	}
+	if _, ok := tree.Delete(1); ok {
+		t.Fatal("Delete of a deleted key reported true")
+	}
+	if _, ok := tree.Delete(9); ok {
+		t.Fatal("Delete of a missing key reported true")
+	}
+	if tree.Seq() != uint64(len(steps)) {
+		t.Fatalf("a Delete that removed nothing consumed a sequence: Seq = %d", tree.Seq())
+	}
+
+	for seq, want := range states {
+		for key := 1; key <= 3; key++ {
+			got, ok := tree.GetAt(key, uint64(seq))
+			if w, present := want[key]; ok != present || got != w {
+				t.Errorf("GetAt(%d, %d) = %q, %t, want %q, %t", key, seq, got, ok, w, present)
+			}
+		}
+		if got := versioned_collect(tree, uint64(seq)); !maps.Equal(got, want) {
+			t.Errorf("RangeAt at %d = %v, want %v", seq, got, want)
+		}
+	}
+	if got, ok := tree.Get(2); !ok || got != "b2" {
+		t.Fatalf("Get(2) = %q, %t, want b2", got, ok)
+	}
+}
+
+func TestVersionedLen(t *testing.T) {
+	tree := NewVersioned[int, string](WithOrder(4), WithEntries(3))
+	tests := []struct {
+		op  func()
+		len int
+	}{
+		{func() { tree.Put(1, "a") }, 1},
+		{func() { tree.Put(2, "b") }, 2},
+		{func() { tree.Put(1, "a2") }, 2},
+		{func() { tree.Delete(1) }, 1},
+		{func() { tree.Delete(1) }, 1},
+		{func() { tree.Put(1, "a3") }, 2},
+		{func() { tree.Delete(2) }, 1},
+		{func() { tree.GC() }, 1},
+		{func() { tree.Put(2, "b2") }, 2},
+		{func() { tree.Delete(1); tree.Delete(2) }, 0},
+		{func() { tree.GC() }, 0},
+	}
+	for i, tt := range tests {
+		tt.op()
+		if got := tree.Len(); got != tt.len {
+			t.Fatalf("step %d: Len = %d, want %d", i, got, tt.len)
+		}
+	}
+}
+
+func TestVersionedGC(t *testing.T) {
+	tree := NewVersioned[int, string](WithOrder(4), WithEntries(3))
+	for k := 0; k < 50; k++ {
+		tree.Put(k, "v1")
+	}
+	early := tree.Seq()
+	view := tree.View()
+	for k := 0; k < 50; k++ {
+		tree.Put(k, "v2")
+	}
+	for k := 0; k < 50; k += 2 {
+		tree.Delete(k)
+	}
+	late := tree.Seq()
+
+	/* the view pins its sequence: nothing it can see is pruned */
+	pruned := tree.GC()
+	if pruned != 0 {
+		t.Fatalf("GC with a view open at the oldest history pruned %d versions", pruned)
+	}
+	for k := 0; k < 50; k++ {
+		if got, ok := view.Get(k); !ok || got != "v1" {
+			t.Fatalf("view Get(%d) = %q, %t, want v1", k, got, ok)
+		}
+	}
+	n := 0
+	for range view.Range(0, 1000) {
+		n++
+	}
+	if n != 50 {
+		t.Fatalf("view Range gave %d keys, want 50", n)
+	}
+
+	/*
+	 * with the view released the odd keys lose v1, and the even keys lose
+	 * v1, v2 and then the tombstone itself as the key is dropped
+	 */
+	view.Release()
+	if pruned = tree.GC(); pruned != 25+25*3 {
+		t.Fatalf("GC pruned %d versions, want 100", pruned)
+	}
+	if got := tree.tree.Len(); got != 25 {
+		t.Fatalf("tree holds %d chains after GC, want 25", got)
+	}
+	for k := 0; k < 50; k++ {
+		got, ok := tree.GetAt(k, late)
+		if ok != (k%2 == 1) || (ok && got != "v2") {
+			t.Fatalf("GetAt(%d, %d) = %q, %t after GC", k, late, got, ok)
+		}
+		/* below the horizon the pruned history reads as absent */
+		if _, ok := tree.GetAt(k, early); ok {
+			t.Fatalf("GetAt(%d, %d) below the GC horizon reported present", k, early)
+		}
+	}
+	if pruned = tree.GC(); pruned != 0 {
+		t.Fatalf("a second GC pruned %d versions", pruned)
+	}
+}
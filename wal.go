@@ -0,0 +1,425 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * Write-ahead log
+ *
+ * Every modification of a DiskTree appends one record to the log before
+ * any of the pages it changed may be written to the tree file. A record
+ * holds the full image of each changed page together with the meta
+ * fields after the change, so replaying it is idempotent and restores
+ * all of a split or merge however little of it had reached the file.
+ * The buffer pool only writes a dirty page back once the record that
+ * last changed it is on stable storage.
+ *
+ * A checkpoint writes every changed page to the tree file, records the
+ * lsn of the last record in the meta page and empties the log, which
+ * then only holds the records that came after it.
+ *
+ * The log file starts with a header
+ *
+ *	magic    8 bytes  "bplswal\x00"
+ *	version  4 bytes
+ *	         4 bytes  unused
+ *	base     8 bytes  lsn of the checkpoint the log starts after
+ *
+ * and each record is
+ *
+ *	crc      4 bytes  CRC-32C of the rest of the record
+ *	length   4 bytes  of the rest of the record
+ *	lsn      8 bytes  one more than the record before
+ *	root     8 bytes
+ *	height   4 bytes
+ *	pages    4 bytes  number of page images
+ *	count    8 bytes
+ *	npages   8 bytes
+ *	free     8 bytes
+ *	nfree    8 bytes
+ *
+ * followed by, for each page, its id in 8 bytes and PAGE_SIZE bytes of
+ * contents. Replay stops at the first record that is short, fails its
+ * checksum or is out of sequence, which is where a crash cut the log,
+ * and skips the records a checkpoint already wrote to the file, which a
+ * crash may have left behind before the log was emptied.
+ */
+
+const (
+	WAL_HEADER  = 24
+	WAL_RECORD  = 64
+	WAL_VERSION = 1
+)
+
+const wal_magic = "bplswal\x00"
+
+var crc_table = crc32.MakeTable(crc32.Castagnoli)
+
+/* the file operations a disk tree needs, an *os.File in practice */
+type disk_file interface {
+	io.ReaderAt
+	io.WriterAt
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// SyncPolicy says when a DiskTree forces its write-ahead log to stable
+// storage, trading the durability of the latest modifications for write
+// throughput. Whatever the policy, a crash never leaves a tree half
+// modified: reopening it restores every modification whose log record
+// reached the disk, in order.
+type SyncPolicy struct {
+	interval time.Duration
+}
+
+var (
+	// SyncAlways forces the log after every modification, so one that
+	// has returned survives a crash. It is the default.
+	SyncAlways = SyncPolicy{interval: 0}
+
+	// SyncNever leaves forcing the log to the operating system, and to
+	// Sync and Close.
+	SyncNever = SyncPolicy{interval: -1}
+)
+
+// SyncEvery forces the log every d in the background, so a crash loses
+// at most the modifications of the last d. SyncEvery panics unless d is
+// positive.
+func SyncEvery(d time.Duration) SyncPolicy {
+	if d <= 0 {
+		panic("bplustree: sync interval out of range")
+	}
+	return SyncPolicy{interval: d}
+}
+
+type write_ahead_log struct {
+	mu     sync.Mutex
+	file   disk_file
+	size   int64  /* end of the last record */
+	lsn    uint64 /* last record appended */
+	synced uint64 /* last record on stable storage */
+	policy SyncPolicy
+	err    error /* the log may not end at size, refuse to append */
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+/* append a record of pages and meta, returning its lsn */
+func wal_append(log *write_ahead_log, meta disk_meta, pages []*disk_page) (uint64, error) {
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.err != nil {
+		return 0, log.err
+	}
+	var lsn uint64 = log.lsn + 1
+	buf := make([]byte, WAL_RECORD+len(pages)*(8+PAGE_SIZE))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	binary.LittleEndian.PutUint64(buf[8:], lsn)
+	binary.LittleEndian.PutUint64(buf[16:], uint64(meta.root))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(meta.height))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(len(pages)))
+	binary.LittleEndian.PutUint64(buf[32:], meta.count)
+	binary.LittleEndian.PutUint64(buf[40:], meta.npages)
+	binary.LittleEndian.PutUint64(buf[48:], uint64(meta.free))
+	binary.LittleEndian.PutUint64(buf[56:], meta.nfree)
+	off := WAL_RECORD
+	for _, page := range pages {
+		binary.LittleEndian.PutUint64(buf[off:], uint64(page.id))
+		copy(buf[off+8:], page.buf)
+		off += 8 + PAGE_SIZE
+	}
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(buf[4:], crc_table))
+
+	_, err := log.file.WriteAt(buf, log.size)
+	if err == nil && log.policy == SyncAlways {
+		err = log.file.Sync()
+	}
+	if err != nil {
+		/* drop whatever part of the record made it */
+		if terr := log.file.Truncate(log.size); terr != nil {
+			log.err = terr
+		}
+		return 0, err
+	}
+	log.size += int64(len(buf))
+	log.lsn = lsn
+	if log.policy == SyncAlways {
+		log.synced = lsn
+	}
+	return lsn, nil
+}
+
+/* make sure the record lsn is on stable storage */
+func wal_sync_to(log *write_ahead_log, lsn uint64) error {
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if lsn <= log.synced {
+		return nil
+	}
+	if err := log.file.Sync(); err != nil {
+		return err
+	}
+	log.synced = log.lsn
+	return nil
+}
+
+/* put every record appended so far on stable storage */
+func wal_sync(log *write_ahead_log) error {
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.synced == log.lsn {
+		return nil
+	}
+	if err := log.file.Sync(); err != nil {
+		return err
+	}
+	log.synced = log.lsn
+	return nil
+}
+
+/* the bytes of the log, records and header */
+func wal_size(log *write_ahead_log) int64 {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.size
+}
+
+func wal_header(base uint64) []byte {
+	header := make([]byte, WAL_HEADER)
+	copy(header, wal_magic)
+	binary.LittleEndian.PutUint32(header[8:], WAL_VERSION)
+	binary.LittleEndian.PutUint64(header[16:], base)
+	return header
+}
+
+/* drop every record, the tree file holds all they did */
+func wal_reset(log *write_ahead_log) error {
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.err != nil {
+		return log.err
+	}
+	_, err := log.file.WriteAt(wal_header(log.lsn), 0)
+	if err == nil {
+		err = log.file.Truncate(WAL_HEADER)
+	}
+	if err == nil {
+		err = log.file.Sync()
+	}
+	if err != nil {
+		log.err = err
+		return err
+	}
+	log.size = WAL_HEADER
+	log.synced = log.lsn
+	return nil
+}
+
+/* force the log every interval until wal_close */
+func wal_syncer(log *write_ahead_log) {
+	defer close(log.done)
+	ticker := time.NewTicker(log.policy.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wal_sync(log)
+		case <-log.stop:
+			return
+		}
+	}
+}
+
+/*
+ * Read the log, calling fn for each intact record after the checkpoint
+ * in order, and cut off whatever follows the last one. A new log gets
+ * its header.
+ */
+func wal_open(log *write_ahead_log, file disk_file, policy SyncPolicy, checkpoint uint64, fn func(meta disk_meta, ids []pgid, images [][]byte) error) error {
+
+	log.file = file
+	log.policy = policy
+	log.lsn = checkpoint
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < WAL_HEADER {
+		if _, err := file.WriteAt(wal_header(checkpoint), 0); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		log.size = WAL_HEADER
+	} else {
+		base, err := wal_read_header(file, checkpoint)
+		if err != nil {
+			return err
+		}
+		log.lsn = base
+		if err := wal_replay(log, info.Size(), checkpoint, fn); err != nil {
+			return err
+		}
+		if log.lsn < checkpoint {
+			/* a crash cut short the checkpoint emptying the log */
+			log.lsn = checkpoint
+			log.size = WAL_HEADER
+			if _, err := file.WriteAt(wal_header(checkpoint), 0); err != nil {
+				return err
+			}
+		}
+		if log.size < info.Size() {
+			/* a torn record, or records the checkpoint wrote to the file */
+			if err := file.Truncate(log.size); err != nil {
+				return err
+			}
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	log.synced = log.lsn
+	return nil
+}
+
+/* the base of a log following checkpoint */
+func wal_read_header(file disk_file, checkpoint uint64) (uint64, error) {
+	header := make([]byte, WAL_HEADER)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if string(header[:8]) != wal_magic {
+		return 0, fmt.Errorf("%w: bad log magic", ErrInvalidFile)
+	}
+	if v := binary.LittleEndian.Uint32(header[8:]); v != WAL_VERSION {
+		return 0, fmt.Errorf("%w: unsupported log version %d", ErrInvalidFile, v)
+	}
+	base := binary.LittleEndian.Uint64(header[16:])
+	if base > checkpoint {
+		return 0, fmt.Errorf("%w: log starts at record %d, after checkpoint %d", ErrInvalidFile, base+1, checkpoint)
+	}
+	return base, nil
+}
+
+/*
+ * Fail with ErrNeedsRecovery if the log at path holds records after the
+ * checkpoint, without changing it. A missing log holds none.
+ */
+func wal_check(path string, checkpoint uint64) error {
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() < WAL_HEADER {
+		return err
+	}
+	log := write_ahead_log{file: file}
+	if log.lsn, err = wal_read_header(file, checkpoint); err != nil {
+		return err
+	}
+	return wal_replay(&log, info.Size(), checkpoint, func(disk_meta, []pgid, [][]byte) error {
+		return ErrNeedsRecovery
+	})
+}
+
+/* start forcing the log in the background if the policy asks for it */
+func wal_start(log *write_ahead_log) {
+	if log.policy.interval > 0 {
+		log.stop = make(chan struct{})
+		log.done = make(chan struct{})
+		go wal_syncer(log)
+	}
+}
+
+func wal_replay(log *write_ahead_log, end int64, checkpoint uint64, fn func(meta disk_meta, ids []pgid, images [][]byte) error) error {
+
+	var off int64 = WAL_HEADER
+	head := make([]byte, 8)
+
+	for off+WAL_RECORD <= end {
+		if _, err := log.file.ReadAt(head, off); err != nil {
+			return err
+		}
+		length := int64(binary.LittleEndian.Uint32(head[4:]))
+		if length < WAL_RECORD-8 || off+8+length > end {
+			break
+		}
+		buf := make([]byte, 8+length)
+		if _, err := log.file.ReadAt(buf, off); err != nil {
+			return err
+		}
+		if crc32.Checksum(buf[4:], crc_table) != binary.LittleEndian.Uint32(buf) {
+			break
+		}
+		lsn := binary.LittleEndian.Uint64(buf[8:])
+		n := int64(binary.LittleEndian.Uint32(buf[28:]))
+		if lsn != log.lsn+1 || length != WAL_RECORD-8+n*(8+PAGE_SIZE) {
+			break
+		}
+		meta := disk_meta{
+			root:   pgid(binary.LittleEndian.Uint64(buf[16:])),
+			height: int(binary.LittleEndian.Uint32(buf[24:])),
+			count:  binary.LittleEndian.Uint64(buf[32:]),
+			npages: binary.LittleEndian.Uint64(buf[40:]),
+			free:   pgid(binary.LittleEndian.Uint64(buf[48:])),
+			nfree:  binary.LittleEndian.Uint64(buf[56:]),
+		}
+		ids := make([]pgid, n)
+		images := make([][]byte, n)
+		for i := range ids {
+			rec := buf[WAL_RECORD+i*(8+PAGE_SIZE):]
+			ids[i] = pgid(binary.LittleEndian.Uint64(rec))
+			images[i] = rec[8 : 8+PAGE_SIZE]
+		}
+		if lsn > checkpoint {
+			if err := fn(meta, ids, images); err != nil {
+				return err
+			}
+		}
+		log.lsn = lsn
+		off += 8 + length
+	}
+	log.size = off
+	return nil
+}
+
+func wal_close(log *write_ahead_log) error {
+	if log.stop != nil {
+		close(log.stop)
+		<-log.done
+		log.stop = nil
+	}
+	err := wal_sync(log)
+	if cerr := log.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
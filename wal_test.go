@@ -0,0 +1,329 @@
+package bplustree
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+)
+
+/*
+ * Crash injection
+ *
+ * A crash_file is a disk_file kept in memory that remembers which of
+ * its writes have been synced. All the files of a tree share one
+ * crash_disk, which lets through a budget of bytes and then crashes:
+ * the write that overruns it is cut short, and it and everything after
+ * fail. What survives a crash is the synced image with any subset of the
+ * later writes on top, each whole, missing or torn at sector bounds.
+ */
+
+var errCrash = errors.New("crash")
+
+const crash_sector = 512
+
+type crash_write struct {
+	off   int64
+	data  []byte
+	trunc bool
+}
+
+/* a write the disk let through, and which part of the tree made it */
+type crash_site struct {
+	kind  string
+	start int64 /* bytes written before it */
+	size  int64
+}
+
+type crash_disk struct {
+	mu      sync.Mutex
+	budget  int64 /* bytes left before the crash, negative for no limit */
+	written int64
+	crashed bool
+	phase   string
+	sites   []crash_site
+}
+
+type crash_file struct {
+	disk    *crash_disk
+	log     bool
+	image   []byte
+	synced  []byte
+	pending []crash_write
+}
+
+type crash_info struct {
+	os.FileInfo
+	size int64
+}
+
+func (info crash_info) Size() int64 { return info.size }
+
+func crash_apply(image []byte, w crash_write) []byte {
+	if w.trunc {
+		if w.off < int64(len(image)) {
+			return image[:w.off]
+		}
+		return append(image, make([]byte, w.off-int64(len(image)))...)
+	}
+	if end := w.off + int64(len(w.data)); end > int64(len(image)) {
+		image = append(image, make([]byte, end-int64(len(image)))...)
+	}
+	copy(image[w.off:], w.data)
+	return image
+}
+
+/* what a write to f at off is, judged by the file, the offset and the tree's phase */
+func (f *crash_file) kind(off int64) string {
+	switch {
+	case f.log:
+		return "wal append"
+	case off < PAGE_SIZE && f.disk.phase == "checkpoint":
+		return "checkpoint meta"
+	case f.disk.phase == "modify":
+		return "eviction"
+	}
+	return f.disk.phase
+}
+
+func (f *crash_file) ReadAt(p []byte, off int64) (int, error) {
+	f.disk.mu.Lock()
+	defer f.disk.mu.Unlock()
+	if off >= int64(len(f.image)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.image[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *crash_file) WriteAt(p []byte, off int64) (int, error) {
+	d := f.disk
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.crashed {
+		return 0, errCrash
+	}
+	n := int64(len(p))
+	if d.budget >= 0 && n > d.budget {
+		n, d.crashed = d.budget, true
+	}
+	d.sites = append(d.sites, crash_site{kind: f.kind(off), start: d.written, size: int64(len(p))})
+	w := crash_write{off: off, data: bytes.Clone(p[:n])}
+	f.image = crash_apply(f.image, w)
+	f.pending = append(f.pending, w)
+	d.written += n
+	if d.budget >= 0 {
+		d.budget -= n
+	}
+	if d.crashed {
+		return int(n), errCrash
+	}
+	return len(p), nil
+}
+
+func (f *crash_file) Truncate(size int64) error {
+	f.disk.mu.Lock()
+	defer f.disk.mu.Unlock()
+	if f.disk.crashed {
+		return errCrash
+	}
+	w := crash_write{off: size, trunc: true}
+	f.image = crash_apply(f.image, w)
+	f.pending = append(f.pending, w)
+	return nil
+}
+
+func (f *crash_file) Sync() error {
+	f.disk.mu.Lock()
+	defer f.disk.mu.Unlock()
+	if f.disk.crashed {
+		return errCrash
+	}
+	f.synced = bytes.Clone(f.image)
+	f.pending = nil
+	return nil
+}
+
+func (f *crash_file) Stat() (os.FileInfo, error) {
+	f.disk.mu.Lock()
+	defer f.disk.mu.Unlock()
+	return crash_info{size: int64(len(f.image))}, nil
+}
+
+func (f *crash_file) Close() error { return nil }
+
+/* the file as found after the crash */
+func (f *crash_file) survivor(r *rand.Rand, disk *crash_disk) *crash_file {
+	image := bytes.Clone(f.synced)
+	for _, w := range f.pending {
+		switch r.Intn(3) {
+		case 0:
+			/* lost */
+		case 1:
+			image = crash_apply(image, w)
+		case 2:
+			if w.trunc {
+				continue
+			}
+			for s := 0; s < len(w.data); s += crash_sector {
+				if r.Intn(2) == 0 {
+					e := min(s+crash_sector, len(w.data))
+					image = crash_apply(image, crash_write{off: w.off + int64(s), data: w.data[s:e]})
+				}
+			}
+		}
+	}
+	return &crash_file{disk: disk, log: f.log, image: image, synced: bytes.Clone(image)}
+}
+
+type crash_op struct {
+	delete bool
+	key    int64
+	value  int64
+}
+
+type crash_run struct {
+	disk       *crash_disk
+	file, log  *crash_file
+	ops        []crash_op /* every operation attempted, the one that crashed included */
+	acked      int        /* operations that returned */
+	checkpoint int        /* operations before the last checkpoint that returned */
+}
+
+/* run the seeded workload on fresh files until it finishes or the disk crashes */
+func crash_workload(t *testing.T, seed int64, c config, budget int64) *crash_run {
+	t.Helper()
+
+	disk := &crash_disk{budget: -1, phase: "create"}
+	run := &crash_run{disk: disk, file: &crash_file{disk: disk}, log: &crash_file{disk: disk, log: true}}
+	tree, err := disk_tree_init(run.file, run.log, cmp.Compare[int64], Int64Codec(), Int64Codec(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &DiskTree[int64, int64]{tree: tree}
+
+	disk.mu.Lock()
+	disk.budget, disk.written, disk.sites = budget, 0, nil
+	disk.mu.Unlock()
+
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 300; i++ {
+		op := crash_op{delete: r.Intn(3) == 0, key: int64(r.Intn(150)), value: r.Int63()}
+		run.ops = append(run.ops, op)
+		disk.phase = "modify"
+		if op.delete {
+			_, _, err = d.Delete(op.key)
+		} else {
+			_, _, err = d.Put(op.key, op.value)
+		}
+		if err != nil {
+			break
+		}
+		run.acked++
+		if r.Intn(25) == 0 {
+			disk.phase = "checkpoint"
+			if err = disk_tree_checkpoint(tree); err != nil {
+				break
+			}
+			run.checkpoint = run.acked
+		}
+	}
+	if err != nil && !errors.Is(err, errCrash) {
+		t.Fatalf("seed %d: %v", seed, err)
+	}
+	return run
+}
+
+/* reopen the files the crash left, which must hold a prefix of the operations no shorter than durable */
+func crash_recover(run *crash_run, r *rand.Rand, c config, durable int) error {
+	disk := &crash_disk{budget: -1, phase: "recovery"}
+	tree, err := disk_tree_init(run.file.survivor(r, disk), run.log.survivor(r, disk), cmp.Compare[int64], Int64Codec(), Int64Codec(), c)
+	if err != nil {
+		return fmt.Errorf("reopen: %w", err)
+	}
+	defer disk_tree_close(tree)
+
+	violations, err := disk_tree_check(tree)
+	if err != nil || len(violations) != 0 {
+		return fmt.Errorf("Check() = %v, %v", violations, err)
+	}
+	found := make(map[int64]int64)
+	err = (&DiskTree[int64, int64]{tree: tree}).Ascend(func(key, value int64) bool {
+		found[key] = value
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	model := make(map[int64]int64)
+	for n := 0; ; n++ {
+		if n >= durable && maps.Equal(model, found) {
+			return nil
+		}
+		if n == len(run.ops) {
+			return fmt.Errorf("holds %d keys, no prefix of the %d operations from %d on", len(found), len(run.ops), durable)
+		}
+		if op := run.ops[n]; op.delete {
+			delete(model, op.key)
+		} else {
+			model[op.key] = op.value
+		}
+	}
+}
+
+func TestWALCrash(t *testing.T) {
+	kinds := []string{"wal append", "eviction", "checkpoint meta"}
+	for _, policy := range []SyncPolicy{SyncAlways, SyncNever} {
+		/* no cache beyond the minimum, and small nodes, so records span many pages */
+		c := config{order: 4, entries: 4, cache: 0, sync: policy}
+		crashed := make(map[string]int)
+		for trial := 0; trial < 60; trial++ {
+			seed := int64(trial / len(kinds))
+			kind := kinds[trial%len(kinds)]
+
+			/* a clean run of the workload finds the writes of the kind to crash in */
+			var sites []crash_site
+			for _, site := range crash_workload(t, seed, c, -1).disk.sites {
+				if site.kind == kind {
+					sites = append(sites, site)
+				}
+			}
+			if len(sites) == 0 {
+				t.Fatalf("policy %v, seed %d: the workload makes no %s", policy, seed, kind)
+			}
+			r := rand.New(rand.NewSource(int64(trial)))
+			site := sites[r.Intn(len(sites))]
+			run := crash_workload(t, seed, c, site.start+r.Int63n(site.size))
+			if !run.disk.crashed || run.disk.sites[len(run.disk.sites)-1].kind != kind {
+				t.Fatalf("policy %v, trial %d: the disk did not crash in a %s", policy, trial, kind)
+			}
+			crashed[kind]++
+
+			/* whatever was acknowledged with the log forced, or checkpointed, survives */
+			durable := run.checkpoint
+			if policy == SyncAlways {
+				durable = run.acked
+			}
+			for i := 0; i < 4; i++ {
+				if err := crash_recover(run, r, c, durable); err != nil {
+					t.Fatalf("policy %v, trial %d, crash in a %s after %d operations: %v", policy, trial, kind, len(run.ops), err)
+				}
+			}
+		}
+		for _, kind := range kinds {
+			if crashed[kind] == 0 {
+				t.Fatalf("policy %v: no crash in a %s", policy, kind)
+			}
+		}
+	}
+}